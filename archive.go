@@ -0,0 +1,466 @@
+package migrations
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Archive formats of SourceArchive and Bundle.
+const (
+	ArchiveTarGz = "tar.gz"
+	ArchiveZip   = "zip"
+)
+
+// ArchiveFormat returns archive format by file name extension.
+func ArchiveFormat(name string) (string, error) {
+	switch {
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return ArchiveTarGz, nil
+	case strings.HasSuffix(name, ".zip"):
+		return ArchiveZip, nil
+	}
+	return "", fmt.Errorf("unknown archive format of %s, expected .tar.gz, .tgz or .zip", name)
+}
+
+// manifestFile is stored in root of bundle.
+const manifestFile = "manifest.json"
+
+// bundleDir keeps migrations in bundle.
+const bundleDir = "migrations"
+
+// Manifest describes migrations of bundle, see Bundle.
+type Manifest struct {
+	Version   string    `json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+	// Dir is migrations directory in archive.
+	Dir        string          `json:"dir"`
+	Migrations []ManifestEntry `json:"migrations"`
+}
+
+// ManifestEntry records migration with options derived from its path,
+// so that moving file to other directory does not go unnoticed.
+type ManifestEntry struct {
+	ID         string   `json:"id"`
+	Checksum   string   `json:"checksum"`
+	Phase      string   `json:"phase,omitempty"`
+	Tags       []string `json:"tags,omitempty"`
+	Repeatable bool     `json:"repeatable,omitempty"`
+}
+
+func newManifestEntry(mig Migration) ManifestEntry {
+	return ManifestEntry{
+		ID:         mig.ID,
+		Checksum:   mig.Checksum,
+		Phase:      mig.Phase,
+		Tags:       mig.Tags,
+		Repeatable: mig.Repeatable,
+	}
+}
+
+// Verify checks that migrations match manifest, including their order, phases and tags.
+func (m *Manifest) Verify(migs []Migration) error {
+	if len(migs) != len(m.Migrations) {
+		return fmt.Errorf("manifest lists %d migrations, found %d", len(m.Migrations), len(migs))
+	}
+	for i, entry := range m.Migrations {
+		mig := migs[i]
+		if mig.ID != entry.ID {
+			return fmt.Errorf("manifest lists migration %s at position %d, found %s", entry.ID, i+1, mig.ID)
+		}
+		if mig.Checksum != entry.Checksum {
+			return fmt.Errorf("checksum of migration %s does not match manifest", mig.ID)
+		}
+		actual := newManifestEntry(mig)
+		if actual.Phase != entry.Phase || strings.Join(actual.Tags, ",") != strings.Join(entry.Tags, ",") || actual.Repeatable != entry.Repeatable {
+			return fmt.Errorf("phase, tags or repeatable flag of migration %s do not match manifest", mig.ID)
+		}
+	}
+	return nil
+}
+
+func newManifest(version string, migs []Migration) *Manifest {
+	m := Manifest{
+		Version:    version,
+		CreatedAt:  time.Now().UTC().Truncate(time.Second),
+		Dir:        bundleDir,
+		Migrations: make([]ManifestEntry, 0, len(migs)),
+	}
+	for _, mig := range migs {
+		m.Migrations = append(m.Migrations, newManifestEntry(mig))
+	}
+	return &m
+}
+
+func readManifest(fsys fs.FS) (*Manifest, error) {
+	content, err := fs.ReadFile(fsys, manifestFile)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("could not read manifest: %w", err)
+	}
+	var m Manifest
+	if err := json.Unmarshal(content, &m); err != nil {
+		return nil, fmt.Errorf("could not parse manifest: %w", err)
+	}
+	return &m, nil
+}
+
+// SourceArchive reads migrations from tar.gz or zip archive, for example created by Bundle.
+// Archive with manifest is verified against it.
+type SourceArchive struct {
+	Path string
+	// Options of reading migrations, see SourceFS. Its FS is archive contents,
+	// Dir defaults to directory from manifest or to archive root.
+	Options SourceFS
+	// RequireManifest fails on archives without manifest.
+	RequireManifest bool
+}
+
+func (sa *SourceArchive) Migrations() ([]Migration, error) {
+	contents, err := sa.load()
+	if err != nil {
+		return nil, err
+	}
+	return contents.migs, nil
+}
+
+// Manifest returns manifest of archive, nil if it has none.
+func (sa *SourceArchive) Manifest() (*Manifest, error) {
+	contents, err := sa.load()
+	if err != nil {
+		return nil, err
+	}
+	return contents.manifest, nil
+}
+
+// archiveContents are verified migrations of archive with its manifest and signature, if any.
+type archiveContents struct {
+	migs      []Migration
+	manifest  *Manifest
+	signature []byte
+}
+
+func (sa *SourceArchive) load() (*archiveContents, error) {
+	fsys, err := openArchive(sa.Path)
+	if err != nil {
+		return nil, fmt.Errorf("could not open archive %s: %w", sa.Path, err)
+	}
+	if closer, ok := fsys.(io.Closer); ok {
+		defer closer.Close()
+	}
+	return sa.read(fsys)
+}
+
+func (sa *SourceArchive) read(fsys fs.FS) (*archiveContents, error) {
+	manifest, err := readManifest(fsys)
+	if err != nil {
+		return nil, fmt.Errorf("archive %s: %w", sa.Path, err)
+	}
+	if manifest == nil && sa.RequireManifest {
+		return nil, fmt.Errorf("archive %s has no manifest", sa.Path)
+	}
+	signature, err := readSignature(fsys)
+	if err != nil {
+		return nil, fmt.Errorf("archive %s: %w", sa.Path, err)
+	}
+
+	sf := sa.Options
+	sf.FS = fsys
+	// archive is closed after reading, so its files can not be streamed
+	sf.StreamThreshold = 0
+	if sf.Dir == "" && manifest != nil {
+		sf.Dir = manifest.Dir
+	}
+
+	migs, err := sf.Migrations()
+	if err != nil {
+		return nil, fmt.Errorf("could not read archive %s: %w", sa.Path, err)
+	}
+
+	if manifest != nil {
+		if err := manifest.Verify(migs); err != nil {
+			return nil, fmt.Errorf("archive %s does not match its manifest: %w", sa.Path, err)
+		}
+	}
+	return &archiveContents{migs: migs, manifest: manifest, signature: signature}, nil
+}
+
+// openArchive returns file system of archive contents,
+// it implements io.Closer when it has to be closed.
+func openArchive(name string) (fs.FS, error) {
+	format, err := ArchiveFormat(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if format == ArchiveZip {
+		return zip.OpenReader(name)
+	}
+
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return readTarGz(f)
+}
+
+// readTarGz reads regular files of tar.gz archive to memory.
+func readTarGz(r io.Reader) (fs.FS, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+	return readTar(gz)
+}
+
+// readTar reads regular files of tar archive to memory.
+func readTar(r io.Reader) (memFS, error) {
+	files := memFS{}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return files, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		name := path.Clean(strings.TrimPrefix(hdr.Name, "./"))
+		if !fs.ValidPath(name) {
+			return nil, fmt.Errorf("invalid file path %s", hdr.Name)
+		}
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, fmt.Errorf("could not read %s: %w", name, err)
+		}
+		files[name] = content
+	}
+}
+
+// Bundle writes files of migrations directory of src to archive of given format,
+// under "migrations" directory, with manifest listing IDs and checksums of migrations.
+// Hidden files are not included, except .migrationsignore.
+func Bundle(w io.Writer, format string, src *SourceFS, version string) (*Manifest, error) {
+	migs, err := src.Migrations()
+	if err != nil {
+		return nil, fmt.Errorf("could not read migrations: %w", err)
+	}
+	manifest := newManifest(version, migs)
+
+	root := src.root()
+	files := map[string][]byte{}
+	err = fs.WalkDir(src.FS, root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p != root && isHidden(d.Name()) && d.Name() != ignoreFile {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		content, err := fs.ReadFile(src.FS, p)
+		if err != nil {
+			return err
+		}
+		files[path.Join(bundleDir, src.relPath(p))] = content
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not read files: %w", err)
+	}
+
+	manifestContent, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("could not encode manifest: %w", err)
+	}
+	files[manifestFile] = append(manifestContent, '\n')
+
+	switch format {
+	case ArchiveTarGz:
+		err = writeTarGz(w, files, manifest.CreatedAt)
+	case ArchiveZip:
+		err = writeZip(w, files, manifest.CreatedAt)
+	default:
+		err = fmt.Errorf("unknown archive format: %s", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+func sortedNames(files map[string][]byte) []string {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func writeTarGz(w io.Writer, files map[string][]byte, modTime time.Time) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	for _, name := range sortedNames(files) {
+		content := files[name]
+		hdr := tar.Header{
+			Name:     name,
+			Mode:     0644,
+			Size:     int64(len(content)),
+			ModTime:  modTime,
+			Typeflag: tar.TypeReg,
+		}
+		if err := tw.WriteHeader(&hdr); err != nil {
+			return fmt.Errorf("could not write %s: %w", name, err)
+		}
+		if _, err := tw.Write(content); err != nil {
+			return fmt.Errorf("could not write %s: %w", name, err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("could not close tar: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return fmt.Errorf("could not close gzip: %w", err)
+	}
+	return nil
+}
+
+func writeZip(w io.Writer, files map[string][]byte, modTime time.Time) error {
+	zw := zip.NewWriter(w)
+	for _, name := range sortedNames(files) {
+		fw, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     name,
+			Method:   zip.Deflate,
+			Modified: modTime,
+		})
+		if err != nil {
+			return fmt.Errorf("could not write %s: %w", name, err)
+		}
+		if _, err := fw.Write(files[name]); err != nil {
+			return fmt.Errorf("could not write %s: %w", name, err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("could not close zip: %w", err)
+	}
+	return nil
+}
+
+// memFS is read only file system of files in memory, keyed by slash separated path.
+type memFS map[string][]byte
+
+func (m memFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	if content, ok := m[name]; ok {
+		return &memFile{info: memFileInfo{name: path.Base(name), size: int64(len(content))}, r: bytes.NewReader(content)}, nil
+	}
+	entries, err := m.ReadDir(name)
+	if err != nil {
+		return nil, err
+	}
+	return &memFile{info: memFileInfo{name: path.Base(name), dir: true}, entries: entries}, nil
+}
+
+func (m memFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	prefix := name + "/"
+	if name == "." {
+		prefix = ""
+	}
+
+	children := map[string]memFileInfo{}
+	for p, content := range m {
+		if !strings.HasPrefix(p, prefix) {
+			continue
+		}
+		child, rest, nested := cut(strings.TrimPrefix(p, prefix), "/")
+		if nested && rest != "" {
+			children[child] = memFileInfo{name: child, dir: true}
+		} else {
+			children[child] = memFileInfo{name: child, size: int64(len(content))}
+		}
+	}
+	if len(children) == 0 && name != "." {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+
+	entries := make([]fs.DirEntry, 0, len(children))
+	for _, info := range children {
+		entries = append(entries, fs.FileInfoToDirEntry(info))
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	return entries, nil
+}
+
+type memFile struct {
+	info    memFileInfo
+	r       *bytes.Reader
+	entries []fs.DirEntry
+}
+
+func (f *memFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *memFile) Close() error               { return nil }
+
+func (f *memFile) Read(b []byte) (int, error) {
+	if f.info.dir {
+		return 0, &fs.PathError{Op: "read", Path: f.info.name, Err: errors.New("is a directory")}
+	}
+	return f.r.Read(b)
+}
+
+// ReadDir makes directories readable by fs.ReadDir.
+func (f *memFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	entries := f.entries
+	if n > 0 && len(entries) > n {
+		entries = entries[:n]
+	}
+	f.entries = f.entries[len(entries):]
+	if n > 0 && len(entries) == 0 {
+		return nil, io.EOF
+	}
+	return entries, nil
+}
+
+type memFileInfo struct {
+	name string
+	size int64
+	dir  bool
+}
+
+func (fi memFileInfo) Name() string { return fi.name }
+func (fi memFileInfo) Size() int64  { return fi.size }
+func (fi memFileInfo) Mode() fs.FileMode {
+	if fi.dir {
+		return fs.ModeDir | 0555
+	}
+	return 0444
+}
+func (fi memFileInfo) ModTime() time.Time { return time.Time{} }
+func (fi memFileInfo) IsDir() bool        { return fi.dir }
+func (fi memFileInfo) Sys() interface{}   { return nil }
@@ -0,0 +1,146 @@
+package migrations_test
+
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	migrations "github.com/ulexxander/go-db-migrations"
+)
+
+var bundledFS = fstest.MapFS{
+	"db/001_init.sql":        {Data: []byte("CREATE TABLE users (id int);\n-- migrate:include _shared/grants.sql\n")},
+	"db/post/002_drop.sql":   {Data: []byte("DROP TABLE legacy;")},
+	"db/_shared/grants.sql":  {Data: []byte("GRANT SELECT ON users TO app;")},
+	"db/.migrationsignore":   {Data: []byte("*.md\n")},
+	"db/README.md":           {Data: []byte("docs")},
+	"db/.DS_Store":           {Data: []byte{}},
+	"other/999_not_used.sql": {Data: []byte("SELECT 1")},
+}
+
+func writeBundle(t *testing.T, name string) (string, *migrations.Manifest) {
+	t.Helper()
+	format, err := migrations.ArchiveFormat(name)
+	if err != nil {
+		t.Fatalf("unexpected format error: %s", err)
+	}
+
+	archivePath := filepath.Join(t.TempDir(), name)
+	f, err := os.Create(archivePath)
+	if err != nil {
+		t.Fatalf("could not create archive: %s", err)
+	}
+	defer f.Close()
+
+	manifest, err := migrations.Bundle(f, format, &migrations.SourceFS{FS: bundledFS, Dir: "db"}, "1.2.0")
+	if err != nil {
+		t.Fatalf("could not bundle migrations: %s", err)
+	}
+	return archivePath, manifest
+}
+
+func TestBundle(t *testing.T) {
+	expected, err := (&migrations.SourceFS{FS: bundledFS, Dir: "db"}).Migrations()
+	if err != nil {
+		t.Fatalf("could not read migrations: %s", err)
+	}
+
+	for _, name := range []string{"bundle.tar.gz", "bundle.zip"} {
+		t.Run(name, func(t *testing.T) {
+			archivePath, manifest := writeBundle(t, name)
+			if manifest.Version != "1.2.0" || len(manifest.Migrations) != 2 {
+				t.Errorf("unexpected manifest: %+v", manifest)
+			}
+
+			src := migrations.SourceArchive{Path: archivePath, RequireManifest: true}
+			migs, err := src.Migrations()
+			if err != nil {
+				t.Fatalf("could not read archive: %s", err)
+			}
+			if migrationIDs(migs) != migrationIDs(expected) {
+				t.Fatalf("unexpected migrations: %s", migrationIDs(migs))
+			}
+			for i, mig := range migs {
+				if mig.Content != expected[i].Content || mig.Phase != expected[i].Phase {
+					t.Errorf("migration %s differs from source: %+v", mig.ID, mig)
+				}
+			}
+		})
+	}
+}
+
+func writeZip(t *testing.T, files map[string]string) string {
+	t.Helper()
+	archivePath := filepath.Join(t.TempDir(), "migrations.zip")
+	f, err := os.Create(archivePath)
+	if err != nil {
+		t.Fatalf("could not create archive: %s", err)
+	}
+	defer f.Close()
+
+	zw := zip.NewWriter(f)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatalf("could not create %s: %s", name, err)
+		}
+		if _, err := w.Write([]byte(content)); err != nil {
+			t.Fatalf("could not write %s: %s", name, err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("could not close archive: %s", err)
+	}
+	return archivePath
+}
+
+func TestSourceArchiveVerifiesManifest(t *testing.T) {
+	archivePath := writeZip(t, map[string]string{
+		"manifest.json":      `{"version": "1", "dir": "migrations", "migrations": [{"id": "001.sql", "checksum": "abc"}]}`,
+		"migrations/001.sql": "SELECT 1",
+		"plain/001.sql":      "SELECT 1",
+		"plain/002.sql":      "SELECT 2",
+	})
+
+	src := migrations.SourceArchive{Path: archivePath}
+	_, err := src.Migrations()
+	if err == nil || !strings.Contains(err.Error(), "checksum of migration 001.sql does not match manifest") {
+		t.Errorf("expected checksum mismatch error, got: %v", err)
+	}
+
+	// manifest is verified with explicit directory too
+	src.Options.Dir = "plain"
+	_, err = src.Migrations()
+	if err == nil || !strings.Contains(err.Error(), "manifest lists 1 migrations, found 2") {
+		t.Errorf("expected manifest mismatch error, got: %v", err)
+	}
+}
+
+func TestSourceArchiveWithoutManifest(t *testing.T) {
+	archivePath := writeZip(t, map[string]string{
+		"001.sql":            "SELECT 1",
+		"nested/002.sql":     "SELECT 2",
+		"nested/.002.sql.sw": "",
+	})
+
+	src := migrations.SourceArchive{Path: archivePath}
+	migs, err := src.Migrations()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if ids := migrationIDs(migs); ids != "001.sql 002.sql" {
+		t.Errorf("unexpected migrations: %s", ids)
+	}
+
+	src.RequireManifest = true
+	if _, err := src.Migrations(); err == nil || !strings.Contains(err.Error(), "has no manifest") {
+		t.Errorf("expected missing manifest error, got: %v", err)
+	}
+
+	if _, err := migrations.ArchiveFormat("bundle.rar"); err == nil {
+		t.Errorf("expected unknown format error")
+	}
+}
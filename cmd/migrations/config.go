@@ -0,0 +1,220 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// defaultConfigFile is loaded from working directory when it exists
+// and no other config file is specified.
+const defaultConfigFile = "migrations.yaml"
+
+// envPrefix of environment variables overriding config file,
+// for example MIGRATIONS_DSN.
+const envPrefix = "MIGRATIONS_"
+
+// settings can be specified in config file, in its environment section,
+// with environment variables and flags, latter taking precedence.
+type settings struct {
+	Dir        string `yaml:"dir"`
+	DSN        string `yaml:"dsn"`
+	DSNFile    string `yaml:"dsn_file"`
+	ScratchDSN string `yaml:"scratch_dsn"`
+	LogFormat  string `yaml:"log_format"`
+	Schema     string `yaml:"schema"`
+	// Tags select tagged migrations, like "seed,dev,!prod", see parseTags.
+	Tags string `yaml:"tags"`
+	// Shards are DSNs of shards command by shard name.
+	Shards map[string]string `yaml:"shards"`
+	// Vars are substituted in migrations, merged by name.
+	Vars map[string]string `yaml:"vars"`
+	// PublicKeys are files of keys trusted to sign bundles,
+	// migrations are read only from signed bundles when set.
+	PublicKeys []string `yaml:"public_keys"`
+	// Modules are migrations directories by module name, merged instead of Dir.
+	Modules map[string]string `yaml:"modules"`
+	// NamespaceModules is pointer, so that false of later level overrides true.
+	NamespaceModules *bool  `yaml:"namespace_modules"`
+	MergeOrder       string `yaml:"merge_order"`
+}
+
+type config struct {
+	settings     `yaml:",inline"`
+	Environments map[string]settings `yaml:"environments"`
+}
+
+// resolveSettings applies config file, environment variables and flags to args,
+// in order of increasing precedence:
+// config file, config file environment, environment variables, flags.
+func resolveSettings(a *args, lookupEnv func(string) (string, bool)) error {
+	configPath := a.config
+	if configPath == "" {
+		configPath, _ = lookupEnv(envPrefix + "CONFIG")
+	}
+	envName := a.env
+	if envName == "" {
+		envName, _ = lookupEnv(envPrefix + "ENV")
+	}
+
+	cfg, err := loadConfig(configPath)
+	if err != nil {
+		return err
+	}
+
+	levels := []settings{cfg.settings}
+	if envName != "" {
+		envSettings, ok := cfg.Environments[envName]
+		if !ok {
+			return fmt.Errorf("environment %s is not defined in config", envName)
+		}
+		levels = append(levels, envSettings)
+	}
+	levels = append(levels, envSettings(lookupEnv), a.flags)
+
+	var resolved settings
+	for _, level := range levels {
+		resolved.merge(level)
+	}
+
+	if resolved.DSNFile != "" {
+		dsn, err := os.ReadFile(resolved.DSNFile)
+		if err != nil {
+			return fmt.Errorf("could not read dsn file: %s", err)
+		}
+		resolved.DSN = strings.TrimSpace(string(dsn))
+	}
+
+	a.dir = resolved.Dir
+	a.dsn = resolved.DSN
+	a.scratchDSN = resolved.ScratchDSN
+	a.schema = resolved.Schema
+	a.shards = resolved.Shards
+	a.vars = resolved.Vars
+	a.publicKeys = resolved.PublicKeys
+	a.modules = resolved.Modules
+	a.namespaceModules = resolved.NamespaceModules != nil && *resolved.NamespaceModules
+	a.includeTags, a.excludeTags = parseTags(resolved.Tags)
+	if resolved.LogFormat != "" {
+		a.logFormat = resolved.LogFormat
+	}
+	if resolved.MergeOrder != "" {
+		a.mergeOrder = resolved.MergeOrder
+	}
+	return nil
+}
+
+// merge overrides settings with non empty values of other.
+// DSN and DSN file override each other.
+func (s *settings) merge(other settings) {
+	if other.Dir != "" {
+		s.Dir = other.Dir
+	}
+	if other.DSN != "" {
+		s.DSN = other.DSN
+		s.DSNFile = ""
+	}
+	if other.DSNFile != "" {
+		s.DSNFile = other.DSNFile
+		s.DSN = ""
+	}
+	if other.ScratchDSN != "" {
+		s.ScratchDSN = other.ScratchDSN
+	}
+	if other.LogFormat != "" {
+		s.LogFormat = other.LogFormat
+	}
+	if other.Schema != "" {
+		s.Schema = other.Schema
+	}
+	if other.Tags != "" {
+		s.Tags = other.Tags
+	}
+	if len(other.Shards) > 0 {
+		s.Shards = other.Shards
+	}
+	if len(other.Modules) > 0 {
+		s.Modules = other.Modules
+	}
+	if other.NamespaceModules != nil {
+		s.NamespaceModules = other.NamespaceModules
+	}
+	if other.MergeOrder != "" {
+		s.MergeOrder = other.MergeOrder
+	}
+	if len(other.PublicKeys) > 0 {
+		s.PublicKeys = other.PublicKeys
+	}
+	for name, value := range other.Vars {
+		if s.Vars == nil {
+			s.Vars = map[string]string{}
+		}
+		s.Vars[name] = value
+	}
+}
+
+// parseTags splits comma separated tags to included ones
+// and excluded ones, which are prefixed with "!".
+func parseTags(value string) (include, exclude []string) {
+	for _, tag := range strings.Split(value, ",") {
+		tag = strings.TrimSpace(tag)
+		switch {
+		case tag == "" || tag == "!":
+		case strings.HasPrefix(tag, "!"):
+			exclude = append(exclude, tag[1:])
+		default:
+			include = append(include, tag)
+		}
+	}
+	return include, exclude
+}
+
+func envSettings(lookupEnv func(string) (string, bool)) settings {
+	get := func(name string) string {
+		val, _ := lookupEnv(envPrefix + name)
+		return val
+	}
+	var publicKeys []string
+	for _, path := range strings.Split(get("PUBLIC_KEYS"), ",") {
+		if path = strings.TrimSpace(path); path != "" {
+			publicKeys = append(publicKeys, path)
+		}
+	}
+	return settings{
+		PublicKeys: publicKeys,
+		Dir:        get("DIR"),
+		DSN:        get("DSN"),
+		DSNFile:    get("DSN_FILE"),
+		ScratchDSN: get("SCRATCH_DSN"),
+		LogFormat:  get("LOG_FORMAT"),
+		Schema:     get("SCHEMA"),
+		Tags:       get("TAGS"),
+	}
+}
+
+// loadConfig reads config file, without path it reads
+// default config file if it exists.
+func loadConfig(path string) (config, error) {
+	var cfg config
+
+	explicit := path != ""
+	if !explicit {
+		path = defaultConfigFile
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		if !explicit && errors.Is(err, os.ErrNotExist) {
+			return cfg, nil
+		}
+		return cfg, fmt.Errorf("could not read config: %s", err)
+	}
+
+	if err := yaml.Unmarshal(content, &cfg); err != nil {
+		return cfg, fmt.Errorf("could not parse config %s: %s", path, err)
+	}
+	return cfg, nil
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testConfig = `
+dir: migrations
+dsn: postgres://localhost/default
+log_format: json
+environments:
+  staging:
+    dsn: postgres://staging/db
+  prod:
+    dir: prod_migrations
+    dsn_file: %s
+`
+
+func lookupEnvMap(env map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		val, ok := env[key]
+		return val, ok
+	}
+}
+
+func writeTestConfig(t *testing.T) (configPath, secretPath string) {
+	t.Helper()
+	dir := t.TempDir()
+	secretPath = filepath.Join(dir, "dsn")
+	if err := os.WriteFile(secretPath, []byte("postgres://prod/db\n"), 0600); err != nil {
+		t.Fatalf("could not write dsn file: %s", err)
+	}
+	configPath = filepath.Join(dir, "migrations.yaml")
+	content := []byte(fmt.Sprintf(testConfig, secretPath))
+	if err := os.WriteFile(configPath, content, 0644); err != nil {
+		t.Fatalf("could not write config: %s", err)
+	}
+	return configPath, secretPath
+}
+
+func TestResolveSettingsPrecedence(t *testing.T) {
+	configPath, _ := writeTestConfig(t)
+
+	tests := []struct {
+		name   string
+		args   args
+		env    map[string]string
+		dir    string
+		dsn    string
+		logFmt string
+	}{
+		{
+			name:   "config defaults",
+			args:   args{config: configPath, logFormat: "text"},
+			dir:    "migrations",
+			dsn:    "postgres://localhost/default",
+			logFmt: "json",
+		},
+		{
+			name:   "config environment",
+			args:   args{config: configPath, env: "staging"},
+			dir:    "migrations",
+			dsn:    "postgres://staging/db",
+			logFmt: "json",
+		},
+		{
+			name:   "dsn file from environment",
+			env:    map[string]string{"MIGRATIONS_CONFIG": configPath, "MIGRATIONS_ENV": "prod"},
+			dir:    "prod_migrations",
+			dsn:    "postgres://prod/db",
+			logFmt: "json",
+		},
+		{
+			name:   "environment variables override config",
+			args:   args{config: configPath, env: "prod"},
+			env:    map[string]string{"MIGRATIONS_DSN": "postgres://env/db", "MIGRATIONS_LOG_FORMAT": "text"},
+			dir:    "prod_migrations",
+			dsn:    "postgres://env/db",
+			logFmt: "text",
+		},
+		{
+			name: "flags override everything",
+			args: args{
+				config: configPath,
+				env:    "prod",
+				flags:  settings{Dir: "flag_dir", DSN: "postgres://flag/db"},
+			},
+			env:    map[string]string{"MIGRATIONS_DSN": "postgres://env/db"},
+			dir:    "flag_dir",
+			dsn:    "postgres://flag/db",
+			logFmt: "json",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := tt.args
+			if err := resolveSettings(&a, lookupEnvMap(tt.env)); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if a.dir != tt.dir {
+				t.Errorf("expected dir %s, got: %s", tt.dir, a.dir)
+			}
+			if a.dsn != tt.dsn {
+				t.Errorf("expected dsn %s, got: %s", tt.dsn, a.dsn)
+			}
+			if a.logFormat != tt.logFmt {
+				t.Errorf("expected log format %s, got: %s", tt.logFmt, a.logFormat)
+			}
+		})
+	}
+}
+
+func TestResolveSettingsErrors(t *testing.T) {
+	configPath, _ := writeTestConfig(t)
+
+	a := args{config: configPath, env: "unknown"}
+	if err := resolveSettings(&a, lookupEnvMap(nil)); err == nil {
+		t.Errorf("expected error for unknown environment, got nil")
+	}
+
+	a = args{config: filepath.Join(t.TempDir(), "missing.yaml")}
+	if err := resolveSettings(&a, lookupEnvMap(nil)); err == nil {
+		t.Errorf("expected error for missing explicit config, got nil")
+	}
+}
+
+func TestParseTags(t *testing.T) {
+	include, exclude := parseTags(" seed, !prod,dev,,!")
+	if strings.Join(include, ",") != "seed,dev" {
+		t.Errorf("unexpected include tags: %v", include)
+	}
+	if strings.Join(exclude, ",") != "prod" {
+		t.Errorf("unexpected exclude tags: %v", exclude)
+	}
+}
+
+func TestMergeVars(t *testing.T) {
+	var s settings
+	s.merge(settings{Vars: map[string]string{"role": "app", "tablespace": "fast"}})
+	s.merge(settings{Vars: map[string]string{"role": "app_prod"}})
+	if s.Vars["role"] != "app_prod" || s.Vars["tablespace"] != "fast" {
+		t.Errorf("unexpected merged vars: %v", s.Vars)
+	}
+}
+
+func TestMergeModules(t *testing.T) {
+	var s settings
+	enabled, disabled := true, false
+	s.merge(settings{Modules: map[string]string{"users": "users/migrations"}, NamespaceModules: &enabled, MergeOrder: "source"})
+	s.merge(settings{Modules: map[string]string{"billing": "billing/migrations"}})
+	if len(s.Modules) != 1 || s.Modules["billing"] != "billing/migrations" {
+		t.Errorf("expected modules replaced by later level, got: %v", s.Modules)
+	}
+	if s.NamespaceModules == nil || !*s.NamespaceModules || s.MergeOrder != "source" {
+		t.Errorf("expected namespace and merge order kept")
+	}
+	s.merge(settings{NamespaceModules: &disabled, MergeOrder: "version"})
+	if *s.NamespaceModules || s.MergeOrder != "version" {
+		t.Errorf("expected namespace and merge order overridden by later level")
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"flag"
+	"testing"
+)
+
+func TestKeyValueFlag(t *testing.T) {
+	kv := keyValueFlag{}
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.Var(kv, "shard", "")
+
+	err := fs.Parse([]string{"-shard", "a=postgres://a/db?sslmode=disable", "-shard", "b=postgres://b/db"})
+	if err != nil {
+		t.Fatalf("could not parse flags: %s", err)
+	}
+	if kv["a"] != "postgres://a/db?sslmode=disable" || kv["b"] != "postgres://b/db" {
+		t.Errorf("unexpected values: %v", kv)
+	}
+
+	if err := kv.Set("=value"); err == nil {
+		t.Errorf("expected error for empty key")
+	}
+	if err := kv.Set("novalue"); err == nil {
+		t.Errorf("expected error without separator")
+	}
+}
+
+func TestListFlag(t *testing.T) {
+	var list listFlag
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.Var(&list, "public-key", "")
+
+	err := fs.Parse([]string{"-public-key", "a.pem", "-public-key", "b.pem"})
+	if err != nil {
+		t.Fatalf("could not parse flags: %s", err)
+	}
+	if list.String() != "a.pem,b.pem" {
+		t.Errorf("unexpected values: %v", list)
+	}
+}
@@ -1,11 +1,22 @@
 package main
 
 import (
+	"context"
+	"crypto/ed25519"
 	"database/sql"
 	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
 
 	_ "github.com/lib/pq"
 	"github.com/sirupsen/logrus"
@@ -17,47 +28,375 @@ type args struct {
 	cmd string
 	dir string
 	dsn string
+	// schema keeping migrations tables, default search_path if empty
+	schema string
+	// tags selecting migrations to execute
+	includeTags []string
+	excludeTags []string
+	// vars substituted in migrations, not rendered if nil
+	vars map[string]string
+	// patterns of migration file names, default if empty
+	patterns     []string
+	nonRecursive bool
+	// migration IDs scheme, base or path
+	idScheme       string
+	stripExtension bool
+	// files larger than it are streamed, in bytes, disabled if zero
+	streamThreshold int64
+	// files of public keys trusted to sign bundles
+	publicKeys []string
+	// source of migrations other than dir, like git:REV
+	source string
+	// migrations directories by module name, merged instead of dir
+	modules          map[string]string
+	namespaceModules bool
+	mergeOrder       string
+
+	// rewrite-ids command args, previous IDs scheme
+	fromIDScheme       string
+	fromStripExtension bool
+
+	logFormat string
+	output    string
+
+	// config file and environment in it
+	config string
+	env    string
+	// settings from flags set explicitly, see resolveSettings
+	flags settings
+
+	// waiting for database, disabled when wait is zero
+	wait migrations.Backoff
 
 	// record command args
 	migrationID string
+
+	// baseline and squash commands args
+	to string
+
+	// squash command args
+	scratchDSN string
+	out        string
+
+	// upgrade and plan commands args, all phases if empty
+	phase string
+
+	// bundle command args
+	bundleVersion string
+
+	// sign command args
+	signingKey string
+
+	// diff command args, source compared to the current one
+	from string
+
+	// serve command args
+	addr string
+
+	// tenants command args
+	schemas      []string
+	schemasQuery string
+
+	// shards command args, DSN by shard name
+	shards map[string]string
+
+	// tenants and shards commands args
+	concurrency     int
+	continueOnError bool
+
+	// create command args
+	name       string
+	versioning string
+	down       bool
+	template   string
 }
 
 func main() {
 	logger := logrus.New()
 	fl := parseArgs()
-	if err := run(fl, logger); err != nil {
-		logger.Fatalf("error: %s", err)
+	p := printer{output: fl.output, w: os.Stdout}
+	if err := run(fl, &p, logger); err != nil {
+		code := exitFailure
+		var ee *exitError
+		if errors.As(err, &ee) {
+			code = ee.code
+		} else if !p.printed {
+			p.result(errorOutput{Error: err.Error()})
+		}
+		logger.Errorf("error: %s", err)
+		os.Exit(code)
 	}
 }
 
 func parseArgs() args {
 	flagSet := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	config := flagSet.String("config", "", "config file path, defaults to "+defaultConfigFile+" if it exists")
+	env := flagSet.String("env", "", "environment from config file to use")
 	dir := flagSet.String("dir", "", "migrations source directory")
 	dsn := flagSet.String("dsn", "", "postgres connection string (dsn)")
+	dsnFile := flagSet.String("dsn-file", "", "file containing postgres connection string (dsn)")
+	schema := flagSet.String("schema", "", "postgres schema keeping migrations tables and used as search_path of migrations, followed by public")
+	tags := flagSet.String("tags", "", "comma separated tags of migrations to execute, tags prefixed with ! are excluded, untagged migrations are always executed")
+	vars := keyValueFlag{}
+	flagSet.Var(vars, "var", "variable name=value substituted for ${name} in migrations, can be repeated")
+	patterns := flagSet.String("patterns", "", "comma separated patterns of migration file names, defaults to *.sql")
+	nonRecursive := flagSet.Bool("non-recursive", false, "do not read migrations from subdirectories")
+	idScheme := flagSet.String("id-scheme", "base", "migration ids from file names (base) or paths relative to dir (path)")
+	stripExtension := flagSet.Bool("strip-extension", false, "remove file extension from migration ids")
+	streamThreshold := flagSet.Int64("stream-threshold", migrations.DefaultStreamThreshold, "size in bytes of migration files streamed instead of read to memory, no streaming if zero")
+	fromIDScheme := flagSet.String("from-id-scheme", "base", "previous id scheme for rewrite-ids command: base or path")
+	fromStripExtension := flagSet.Bool("from-strip-extension", false, "whether previous ids had extension removed, for rewrite-ids command")
+	logFormat := flagSet.String("log-format", "text", "log output format: text or json")
+	waitMax := flagSet.Duration("wait", 0, "max time to wait for database to become available, no waiting if zero")
+	waitBackoff := flagSet.Duration("wait-backoff", 500*time.Millisecond, "initial delay between database connection attempts, doubled after each one")
+	waitBackoffMax := flagSet.Duration("wait-backoff-max", 10*time.Second, "max delay between database connection attempts")
+	output := flagSet.String("output", outputText, "command result output format: text (or table) or json, status also supports yaml")
 	migrationID := flagSet.String("migration-id", "", "migration id to force add in record command")
+	to := flagSet.String("to", "", "last migration id to include in baseline and squash commands")
+	scratchDSN := flagSet.String("scratch-dsn", "", "empty postgres database connection string (dsn) for squash command")
+	out := flagSet.String("out", "", "squashed migration file path for squash command, archive path for bundle command")
+	name := flagSet.String("name", "", "migration name for create command")
+	versioning := flagSet.String("versioning", migrations.VersionTimestamp, "version format for create command: timestamp or sequence")
+	down := flagSet.Bool("down", false, "create paired up and down files in create command")
+	template := flagSet.String("template", "", "migration template file path for create command")
+	phase := flagSet.String("phase", "", "run only migrations of given phase in upgrade, plan, tenants and shards commands: pre or post")
+	bundleVersion := flagSet.String("bundle-version", "", "version recorded in manifest by bundle command, defaults to creation time")
+	modules := keyValueFlag{}
+	flagSet.Var(modules, "module", "module name=dir of migrations, can be repeated, migrations of modules are merged instead of -dir")
+	namespaceModules := flagSet.Bool("namespace-modules", false, "prefix migration ids with module name")
+	mergeOrder := flagSet.String("merge-order", "version", "order of merged migrations of modules: version (by file name) or source (module after module)")
+	source := flagSet.String("source", "", "read migrations of dir at git revision instead of working tree: git:REV")
+	from := flagSet.String("from", "", "source compared to the current one by diff command: git:REV")
+	signingKey := flagSet.String("signing-key", "", "ed25519 private key file (PEM) for sign command")
+	publicKeys := listFlag{}
+	flagSet.Var(&publicKeys, "public-key", "ed25519 public key file (PEM) trusted to sign bundles, can be repeated, migrations are read only from signed bundles when set")
+	addr := flagSet.String("addr", ":8080", "listen address for serve command")
+	schemas := flagSet.String("schemas", "", "comma separated schemas to migrate in tenants command")
+	schemasQuery := flagSet.String("schemas-query", "", "query selecting schemas to migrate in tenants command, used when -schemas is empty")
+	shards := keyValueFlag{}
+	flagSet.Var(shards, "shard", "shard name=dsn for shards command, can be repeated")
+	concurrency := flagSet.Int("concurrency", 1, "max number of schemas or shards migrated at once in tenants and shards commands")
+	continueOnError := flagSet.Bool("continue-on-error", false, "keep migrating other schemas or shards after failure in tenants and shards commands")
 
 	err := flagSet.Parse(os.Args[1:])
 	if err == flag.ErrHelp {
 		flagSet.Usage()
 	}
+	if *output == outputTable {
+		*output = outputText
+	}
+
+	// flags with defaults take part in precedence only when set explicitly
+	var flags settings
+	flagSet.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "dir":
+			flags.Dir = *dir
+		case "dsn":
+			flags.DSN = *dsn
+		case "dsn-file":
+			flags.DSNFile = *dsnFile
+		case "scratch-dsn":
+			flags.ScratchDSN = *scratchDSN
+		case "log-format":
+			flags.LogFormat = *logFormat
+		case "schema":
+			flags.Schema = *schema
+		case "tags":
+			flags.Tags = *tags
+		case "var":
+			flags.Vars = vars
+		case "shard":
+			flags.Shards = shards
+		case "public-key":
+			flags.PublicKeys = publicKeys
+		case "module":
+			flags.Modules = modules
+		case "namespace-modules":
+			flags.NamespaceModules = namespaceModules
+		case "merge-order":
+			flags.MergeOrder = *mergeOrder
+		}
+	})
+
+	wait := migrations.Backoff{
+		Initial: *waitBackoff,
+		Max:     *waitBackoffMax,
+		MaxWait: *waitMax,
+	}
+
+	var patternList []string
+	for _, p := range strings.Split(*patterns, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			patternList = append(patternList, p)
+		}
+	}
+
+	var schemaList []string
+	for _, s := range strings.Split(*schemas, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			schemaList = append(schemaList, s)
+		}
+	}
 
 	return args{
-		cmd:         flagSet.Arg(0),
-		dir:         *dir,
-		dsn:         *dsn,
-		migrationID: *migrationID,
+		cmd:                flagSet.Arg(0),
+		logFormat:          *logFormat,
+		output:             *output,
+		config:             *config,
+		env:                *env,
+		flags:              flags,
+		wait:               wait,
+		migrationID:        *migrationID,
+		to:                 *to,
+		out:                *out,
+		name:               *name,
+		versioning:         *versioning,
+		down:               *down,
+		template:           *template,
+		phase:              *phase,
+		patterns:           patternList,
+		nonRecursive:       *nonRecursive,
+		idScheme:           *idScheme,
+		stripExtension:     *stripExtension,
+		streamThreshold:    *streamThreshold,
+		fromIDScheme:       *fromIDScheme,
+		fromStripExtension: *fromStripExtension,
+		bundleVersion:      *bundleVersion,
+		signingKey:         *signingKey,
+		source:             *source,
+		mergeOrder:         *mergeOrder,
+		from:               *from,
+		addr:               *addr,
+		schemas:            schemaList,
+		schemasQuery:       *schemasQuery,
+		concurrency:        *concurrency,
+		continueOnError:    *continueOnError,
 	}
 }
 
-func run(a args, logger *logrus.Logger) error {
-	if a.dir == "" {
-		return fmt.Errorf("dir flag can not be empty")
+func run(a args, p *printer, logger *logrus.Logger) error {
+	if err := resolveSettings(&a, os.LookupEnv); err != nil {
+		return err
+	}
+	switch a.logFormat {
+	case "text":
+	case "json":
+		logger.SetFormatter(&logrus.JSONFormatter{})
+	default:
+		return fmt.Errorf("unknown log format: %s", a.logFormat)
+	}
+	if a.output != outputText && a.output != outputJSON && !(a.output == outputYAML && a.cmd == "status") {
+		p.output = outputText
+		return fmt.Errorf("unsupported output format: %s", a.output)
+	}
+	if a.dir == "" && len(a.modules) == 0 {
+		return fmt.Errorf("dir can not be empty, set it with flag, %sDIR or config", envPrefix)
+	}
+	if a.dir != "" && len(a.modules) > 0 {
+		return errors.New("dir and modules can not be set together")
+	}
+
+	idScheme, err := parseIDScheme(a.idScheme)
+	if err != nil {
+		return err
 	}
+
+	// reading options, dir is directory or archive of migrations
+	fsOpts := migrations.SourceFS{
+		Vars:            a.vars,
+		Patterns:        a.patterns,
+		NonRecursive:    a.nonRecursive,
+		IDScheme:        idScheme,
+		StripExtension:  a.stripExtension,
+		StreamThreshold: a.streamThreshold,
+	}
+
+	src := sourceDir(a.dir, fsOpts)
+	var source migrations.Source = src
+	var archive *migrations.SourceArchive
+	if len(a.modules) > 0 {
+		switch a.cmd {
+		case "create", "bundle", "sign", "verify":
+			return fmt.Errorf("%s command requires dir, it does not support modules", a.cmd)
+		}
+		if source, err = modulesSource(a, a.source, fsOpts); err != nil {
+			return err
+		}
+	} else if a.source != "" {
+		if source, err = parseSource(a.source, a.dir, fsOpts); err != nil {
+			return err
+		}
+	} else if _, err := migrations.ArchiveFormat(a.dir); err == nil {
+		archive = &migrations.SourceArchive{Path: a.dir, Options: fsOpts}
+		source = archive
+	}
+	isArchive := archive != nil
+
+	// commands reading migrations accept only trusted bundles when public keys are set
+	if len(a.publicKeys) > 0 && a.cmd != "create" && a.cmd != "bundle" && a.cmd != "sign" {
+		if !isArchive {
+			return fmt.Errorf("public keys are set, migrations can be read only from signed bundle, %s is not archive", a.dir)
+		}
+		keys, err := readPublicKeys(a.publicKeys)
+		if err != nil {
+			return err
+		}
+		source = &migrations.SourceSigned{Archive: archive, PublicKeys: keys}
+	}
+
+	switch a.cmd {
+	case "create":
+		return cmdCreate(a, p, logger)
+	case "bundle":
+		if isArchive {
+			return fmt.Errorf("bundle command requires migrations directory")
+		}
+		fsOpts.FS = os.DirFS(a.dir)
+		return cmdBundle(a, &fsOpts, p, logger)
+	case "sign":
+		if !isArchive {
+			return fmt.Errorf("sign command requires bundle archive, %s is not archive", a.dir)
+		}
+		return cmdSign(a, archive, p, logger)
+	case "verify":
+		signed, ok := source.(*migrations.SourceSigned)
+		if !ok {
+			return fmt.Errorf("verify command requires public keys, set them with -public-key flag, %sPUBLIC_KEYS or config", envPrefix)
+		}
+		return cmdVerify(signed, p, logger)
+	case "diff":
+		if a.from == "" {
+			return errors.New("from flag cannot be empty")
+		}
+		var from migrations.Source
+		if len(a.modules) > 0 {
+			from, err = modulesSource(a, a.from, fsOpts)
+		} else {
+			from, err = parseSource(a.from, a.dir, fsOpts)
+		}
+		if err != nil {
+			return err
+		}
+		return cmdDiff(from, source, p, logger)
+	case "squash":
+		// works with scratch database only
+		return cmdSquash(a, source, p, logger)
+	case "shards":
+		// works with shards databases only
+		return cmdShards(a, source, p, logger)
+	case "status", "upgrade", "record", "baseline", "plan", "validate", "check", "serve", "tenants", "rewrite-ids":
+	case "":
+		return fmt.Errorf("command is required, available are: create, bundle, sign, verify, diff, status, upgrade, record, baseline, squash, plan, validate, check, serve, tenants, shards, rewrite-ids")
+	default:
+		return fmt.Errorf("unknown command: %s", a.cmd)
+	}
+
 	if a.dsn == "" {
-		return fmt.Errorf("dsn flag can not be empty")
+		return fmt.Errorf("dsn can not be empty, set it with flag, %sDSN or config", envPrefix)
 	}
 
-	pgdb, err := openPostgres(a.dsn)
+	pgdb, err := openPostgres(a.dsn, a.wait, logger)
 	if pgdb != nil {
 		defer pgdb.Close()
 	}
@@ -65,57 +404,152 @@ func run(a args, logger *logrus.Logger) error {
 		return fmt.Errorf("failed to setup database: %s", err)
 	}
 
-	src := migrations.SourceDir{Dir: a.dir}
-	dbp := migrations.DatabasePostgres{DB: pgdb}
+	if a.cmd == "tenants" {
+		return cmdTenants(a, pgdb, source, p, logger)
+	}
+
+	dbp := migrations.DatabasePostgres{DB: pgdb, Schema: a.schema}
+	u := migrations.Upgrader{
+		Logger:      &migrations.LoggerLogrus{Logger: logger},
+		Source:      source,
+		Database:    &dbp,
+		Phase:       a.phase,
+		IncludeTags: a.includeTags,
+		ExcludeTags: a.excludeTags,
+	}
 
 	switch a.cmd {
 	case "status":
-		return cmdStatus(&dbp, logger)
+		return cmdStatus(&u, p, logger)
 	case "upgrade":
-		return cmdUpgrade(&src, &dbp, logger)
+		return cmdUpgrade(&u, a.wait, p, logger)
 	case "record":
-		return cmdRecord(a.migrationID, &dbp, logger)
-	case "":
-		return fmt.Errorf("command is required, available are: state, upgrade, force")
-	default:
-		return fmt.Errorf("unknown command: %s", a.cmd)
+		return cmdRecord(a.migrationID, &dbp, p, logger)
+	case "baseline":
+		return cmdBaseline(a.to, &u, p, logger)
+	case "plan":
+		return cmdPlan(&u, p, logger)
+	case "validate":
+		return cmdValidate(&u, p, logger)
+	case "check":
+		return cmdCheck(&u, p, logger)
+	case "serve":
+		return cmdServe(a.addr, &u, logger)
+	case "rewrite-ids":
+		if isArchive {
+			return fmt.Errorf("rewrite-ids command requires migrations directory")
+		}
+		return cmdRewriteIDs(a, source, src, &dbp, p, logger)
 	}
+	return nil
 }
 
-func cmdStatus(db migrations.Database, logger *logrus.Logger) error {
-	logger.Println("Loading database status...")
+func cmdCreate(a args, p *printer, logger *logrus.Logger) error {
+	c := migrations.Creator{
+		Dir:        a.dir,
+		Versioning: a.versioning,
+		Down:       a.down,
+		Patterns:   a.patterns,
+	}
+
+	if a.template != "" {
+		tmpl, err := os.ReadFile(a.template)
+		if err != nil {
+			return fmt.Errorf("could not read template: %s", err)
+		}
+		c.Template = string(tmpl)
+	}
 
-	executed, err := db.ExecutedMigrations()
+	paths, err := c.Create(a.name)
 	if err != nil {
-		return fmt.Errorf("could not get database executed migrations: %s", err)
+		return err
+	}
+
+	for _, path := range paths {
+		logger.Println("Created", path)
 	}
+	return p.result(createOutput{Files: paths})
+}
+
+func cmdStatus(u *migrations.Upgrader, p *printer, logger *logrus.Logger) error {
+	logger.Println("Loading database status...")
 
-	logger.Println("Successfully loaded database last state")
+	statuses, err := u.Status()
+	if err != nil {
+		return fmt.Errorf("could not get migrations status: %s", err)
+	}
 
-	if len(executed) == 0 {
-		logger.Println("No migrations executed yet")
-		return nil
+	outstanding := migrations.OutstandingPhases(statuses)
+	if len(outstanding) > 0 {
+		logger.Println("Outstanding phases:", strings.Join(outstanding, ", "))
 	}
 
-	last := executed[0]
-	logger.Println("Last migration executed:", last.ID, "at", last.ExecutedAt)
+	if p.output == outputText {
+		return printStatusTable(p.w, statuses)
+	}
+	if outstanding == nil {
+		outstanding = []string{}
+	}
+	return p.result(statusOutput{Migrations: nonNilStatuses(statuses), Outstanding: outstanding})
+}
 
-	return nil
+func printStatusTable(w io.Writer, statuses []migrations.MigrationStatus) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tSTATE\tPHASE\tTAGS\tEXECUTED AT\tDURATION\tFLAGS")
+	for _, s := range statuses {
+		executedAt := "-"
+		duration := "-"
+		if s.ExecutedAt != nil {
+			executedAt = s.ExecutedAt.Format(time.RFC3339)
+			duration = (time.Duration(s.DurationMS) * time.Millisecond).String()
+		}
+		var flags []string
+		if s.Repeatable {
+			flags = append(flags, "repeatable")
+		}
+		if s.Baseline {
+			flags = append(flags, "baseline")
+		}
+		phase := s.Phase
+		if phase == "" {
+			phase = "-"
+		}
+		tags := "-"
+		if len(s.Tags) > 0 {
+			tags = strings.Join(s.Tags, ",")
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", s.ID, s.State, phase, tags, executedAt, duration, strings.Join(flags, ","))
+	}
+	return tw.Flush()
 }
 
-func cmdUpgrade(src migrations.Source, db migrations.Database, logger *logrus.Logger) error {
+func cmdUpgrade(u *migrations.Upgrader, wait migrations.Backoff, p *printer, logger *logrus.Logger) error {
 	logger.Println("Performing upgrade...")
 
-	u := migrations.Upgrader{
-		Logger:   logger,
-		Source:   src,
-		Database: db,
+	var result *migrations.UpgradeResult
+	var err error
+	if wait.MaxWait > 0 {
+		result, err = u.DoWithRetry(context.Background(), wait)
+	} else {
+		result, err = u.Do()
 	}
-
-	result, err := u.Do()
 	if result != nil {
 		logger.WithField("count", len(result.Executed)).Println("Migrations executed")
 	}
+
+	if p.json() {
+		out := upgradeOutput{Executed: []string{}}
+		if result != nil {
+			out.Executed = migrationIDs(result.Executed)
+		}
+		if err != nil {
+			out.Error = err.Error()
+		}
+		if err := p.result(out); err != nil {
+			return err
+		}
+	}
+
 	if err != nil {
 		return err
 	}
@@ -128,7 +562,104 @@ func cmdUpgrade(src migrations.Source, db migrations.Database, logger *logrus.Lo
 	return nil
 }
 
-func cmdRecord(migID string, db migrations.Database, logger *logrus.Logger) error {
+func cmdTenants(a args, db *sql.DB, src migrations.Source, p *printer, logger *logrus.Logger) error {
+	if len(a.schemas) == 0 && a.schemasQuery == "" {
+		return fmt.Errorf("schemas or schemas-query must be specified")
+	}
+
+	logger.Println("Performing upgrade of tenant schemas...")
+
+	tr := migrations.TenantsRunner{
+		DB:              db,
+		Source:          src,
+		Logger:          &migrations.LoggerLogrus{Logger: logger},
+		Schemas:         a.schemas,
+		SchemasQuery:    a.schemasQuery,
+		Concurrency:     a.concurrency,
+		ContinueOnError: a.continueOnError,
+		Phase:           a.phase,
+		IncludeTags:     a.includeTags,
+		ExcludeTags:     a.excludeTags,
+	}
+	results, err := tr.Run(context.Background())
+
+	if results != nil {
+		summary := migrations.Summarize(results)
+		logger.WithFields(logrus.Fields{
+			"succeeded": summary.Succeeded,
+			"failed":    summary.Failed,
+			"skipped":   summary.Skipped,
+		}).Println("Tenant schemas processed")
+	}
+
+	if p.json() {
+		out := newTenantsOutput(results)
+		if err != nil {
+			out.Error = err.Error()
+		}
+		if err := p.result(out); err != nil {
+			return err
+		}
+	}
+
+	return err
+}
+
+func cmdShards(a args, src migrations.Source, p *printer, logger *logrus.Logger) error {
+	if len(a.shards) == 0 {
+		return fmt.Errorf("shards must be specified with flags or config")
+	}
+
+	names := make([]string, 0, len(a.shards))
+	for name := range a.shards {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	sr := migrations.ShardsRunner{
+		Source:          src,
+		Logger:          &migrations.LoggerLogrus{Logger: logger},
+		Schema:          a.schema,
+		Concurrency:     a.concurrency,
+		ContinueOnError: a.continueOnError,
+		Phase:           a.phase,
+		IncludeTags:     a.includeTags,
+		ExcludeTags:     a.excludeTags,
+	}
+	for _, name := range names {
+		sr.Shards = append(sr.Shards, migrations.Shard{Name: name, DSN: a.shards[name]})
+	}
+
+	logger.Println("Performing upgrade of shards...")
+
+	report, err := sr.Run(context.Background())
+	if report != nil {
+		summary := migrations.Summarize(report.Results)
+		logger.WithFields(logrus.Fields{
+			"succeeded": summary.Succeeded,
+			"failed":    summary.Failed,
+			"skipped":   summary.Skipped,
+			"divergent": len(report.Divergent),
+		}).Println("Shards processed")
+	}
+
+	if p.json() {
+		out := newShardsOutput(report)
+		if err != nil {
+			out.Error = err.Error()
+		}
+		if err := p.result(out); err != nil {
+			return err
+		}
+	}
+
+	if errors.Is(err, migrations.ErrDiverged) {
+		return &exitError{code: exitDrift, err: err}
+	}
+	return err
+}
+
+func cmdRecord(migID string, db migrations.Database, p *printer, logger *logrus.Logger) error {
 	if migID == "" {
 		return errors.New("migration id cannot be empty")
 	}
@@ -151,16 +682,434 @@ func cmdRecord(migID string, db migrations.Database, logger *logrus.Logger) erro
 	}
 
 	log.Println("Force added executed migration")
+	return p.result(recordOutput{ID: migID})
+}
+
+func cmdBaseline(toID string, u *migrations.Upgrader, p *printer, logger *logrus.Logger) error {
+	if toID == "" {
+		return errors.New("to flag cannot be empty")
+	}
+
+	log := logger.WithField("to", toID)
+
+	log.Println("Marking migrations as executed...")
+
+	result, err := u.Baseline(toID)
+	if err != nil {
+		return err
+	}
+
+	log.WithField("count", len(result.Executed)).Println("Baseline recorded")
+	return p.result(baselineOutput{Baselined: migrationIDs(result.Executed)})
+}
+
+func cmdRewriteIDs(a args, source migrations.Source, src *migrations.SourceDir, dbp *migrations.DatabasePostgres, p *printer, logger *logrus.Logger) error {
+	var renames map[string]string
+	if multi, ok := source.(*migrations.SourceMulti); ok {
+		// IDs of modules are rewritten to namespaced ones
+		if !multi.Namespace {
+			return errors.New("rewrite-ids command with modules requires namespace-modules")
+		}
+		var err error
+		if renames, err = multi.NamespaceRenames(); err != nil {
+			return err
+		}
+	} else {
+		fromScheme, err := parseIDScheme(a.fromIDScheme)
+		if err != nil {
+			return err
+		}
+		if renames, err = src.IDRenames(fromScheme, a.fromStripExtension); err != nil {
+			return err
+		}
+	}
+
+	for oldID, newID := range renames {
+		logger.WithField("to", newID).Println("Renaming migration", oldID)
+	}
+	if len(renames) > 0 {
+		if err := dbp.RenameMigrations(renames); err != nil {
+			return err
+		}
+	}
+
+	logger.WithField("count", len(renames)).Println("Migration ids rewritten")
+	return p.result(rewriteIDsOutput{Renamed: renames})
+}
+
+func cmdBundle(a args, src *migrations.SourceFS, p *printer, logger *logrus.Logger) error {
+	if a.out == "" {
+		return errors.New("out flag cannot be empty")
+	}
+	format, err := migrations.ArchiveFormat(a.out)
+	if err != nil {
+		return err
+	}
+
+	version := a.bundleVersion
+	if version == "" {
+		version = time.Now().UTC().Format("20060102150405")
+	}
+
+	f, err := os.OpenFile(a.out, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return fmt.Errorf("could not create bundle: %s", err)
+	}
+	manifest, err := migrations.Bundle(f, format, src, version)
+	if closeErr := f.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("could not write bundle: %s", closeErr)
+	}
+	if err != nil {
+		os.Remove(a.out)
+		return err
+	}
+
+	logger.WithFields(logrus.Fields{
+		"version": manifest.Version,
+		"count":   len(manifest.Migrations),
+	}).Println("Bundle created", a.out)
+	return p.result(bundleOutput{File: a.out, Version: manifest.Version, Migrations: len(manifest.Migrations)})
+}
+
+func cmdSign(a args, archive *migrations.SourceArchive, p *printer, logger *logrus.Logger) error {
+	if a.signingKey == "" {
+		return errors.New("signing-key flag cannot be empty")
+	}
+	content, err := os.ReadFile(a.signingKey)
+	if err != nil {
+		return fmt.Errorf("could not read signing key: %s", err)
+	}
+	key, err := migrations.ParsePrivateKey(content)
+	if err != nil {
+		return err
+	}
+
+	manifest, err := archive.Sign(key)
+	if err != nil {
+		return err
+	}
+
+	logger.WithFields(logrus.Fields{
+		"version": manifest.Version,
+		"count":   len(manifest.Migrations),
+	}).Println("Bundle signed", archive.Path)
+	return p.result(bundleOutput{File: archive.Path, Version: manifest.Version, Migrations: len(manifest.Migrations)})
+}
+
+func cmdVerify(src *migrations.SourceSigned, p *printer, logger *logrus.Logger) error {
+	manifest, err := src.Manifest()
+	if err != nil {
+		return err
+	}
+
+	logger.WithFields(logrus.Fields{
+		"version": manifest.Version,
+		"count":   len(manifest.Migrations),
+	}).Println("Bundle signature verified", src.Archive.Path)
+	return p.result(bundleOutput{File: src.Archive.Path, Version: manifest.Version, Migrations: len(manifest.Migrations)})
+}
+
+func readPublicKeys(paths []string) ([]ed25519.PublicKey, error) {
+	keys := make([]ed25519.PublicKey, 0, len(paths))
+	for _, path := range paths {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("could not read public key: %s", err)
+		}
+		key, err := migrations.ParsePublicKey(content)
+		if err != nil {
+			return nil, fmt.Errorf("public key %s: %s", path, err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func cmdDiff(from, to migrations.Source, p *printer, logger *logrus.Logger) error {
+	diff, err := migrations.DiffSources(from, to)
+	if err != nil {
+		return err
+	}
+
+	for _, mig := range diff.Added {
+		logger.Println("Added", mig.ID)
+	}
+	for _, mig := range diff.Removed {
+		logger.Println("Removed", mig.ID)
+	}
+	for _, mig := range diff.Modified {
+		logger.Println("Modified", mig.ID)
+	}
+	if diff.Empty() {
+		logger.Println("No differences")
+	}
+	return p.result(diffOutput{
+		Added:    migrationIDs(diff.Added),
+		Removed:  migrationIDs(diff.Removed),
+		Modified: migrationIDs(diff.Modified),
+	})
+}
+
+func cmdSquash(a args, src migrations.Source, p *printer, logger *logrus.Logger) error {
+	if a.to == "" {
+		return errors.New("to flag cannot be empty")
+	}
+	if a.scratchDSN == "" {
+		return errors.New("scratch-dsn flag cannot be empty")
+	}
+	if a.out == "" {
+		return errors.New("out flag cannot be empty")
+	}
+
+	scratch, err := openPostgres(a.scratchDSN, a.wait, logger)
+	if scratch != nil {
+		defer scratch.Close()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to setup scratch database: %s", err)
+	}
+
+	log := logger.WithField("to", a.to)
+
+	log.Println("Squashing migrations...")
+
+	s := migrations.Squasher{
+		Logger:  &migrations.LoggerLogrus{Logger: logger},
+		Source:  src,
+		Scratch: &migrations.DatabasePostgres{DB: scratch},
+		Dump: func() (string, error) {
+			return migrations.DumpPostgres(a.scratchDSN)
+		},
+	}
+
+	mig, err := s.Squash(a.to, filepath.Base(a.out))
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(a.out, []byte(mig.Content), 0644); err != nil {
+		return fmt.Errorf("could not write squashed migration: %s", err)
+	}
+
+	log.WithField("count", len(mig.Squashes)).Println("Squashed migration written to", a.out)
+	log.Println("Remove squashed migrations from source directory:", strings.Join(mig.Squashes, ", "))
+	return p.result(squashOutput{File: a.out, Squashes: mig.Squashes})
+}
+
+func cmdPlan(u *migrations.Upgrader, p *printer, logger *logrus.Logger) error {
+	pending, err := u.Plan()
+	if err != nil {
+		return fmt.Errorf("could not plan upgrade: %s", err)
+	}
+
+	out := planOutput{Pending: []plannedMigration{}}
+	for _, mig := range pending {
+		phase := mig.Phase
+		if phase == "" {
+			phase = migrations.PhasePre
+		}
+		logger.WithFields(logrus.Fields{
+			"repeatable": mig.Repeatable,
+			"phase":      phase,
+		}).Println("Pending migration", mig.ID)
+		out.Pending = append(out.Pending, plannedMigration{
+			ID:         mig.ID,
+			Repeatable: mig.Repeatable,
+			Phase:      phase,
+			Checksum:   mig.Checksum,
+		})
+	}
+
+	if len(pending) == 0 {
+		logger.Println("No upgrade is needed")
+	}
+
+	branches, err := u.Branches()
+	if err != nil {
+		return fmt.Errorf("could not get independent branches: %s", err)
+	}
+	out.Branches = [][]string{}
+	for i, branch := range branches {
+		ids := migrationIDs(branch)
+		logger.WithField("branch", i+1).Println("Independent branch", strings.Join(ids, ", "))
+		out.Branches = append(out.Branches, ids)
+	}
+
+	return p.result(out)
+}
+
+func cmdValidate(u *migrations.Upgrader, p *printer, logger *logrus.Logger) error {
+	statuses, err := u.Status()
+	if err != nil {
+		return fmt.Errorf("could not get migrations status: %s", err)
+	}
+
+	problems := migrations.FilterStatuses(statuses, migrations.StateModified, migrations.StateMissing)
+	for _, s := range problems {
+		logger.WithField("state", s.State).Warnln("Executed migration does not match source", s.ID)
+	}
+
+	if err := p.result(validateOutput{
+		Valid:    len(problems) == 0,
+		Problems: nonNilStatuses(problems),
+	}); err != nil {
+		return err
+	}
+
+	if len(problems) != 0 {
+		return &exitError{exitDrift, fmt.Errorf("%d executed migrations do not match source", len(problems))}
+	}
+
+	logger.Println("Executed migrations match source")
 	return nil
 }
 
-func openPostgres(dsn string) (*sql.DB, error) {
+func cmdCheck(u *migrations.Upgrader, p *printer, logger *logrus.Logger) error {
+	statuses, err := u.Status()
+	if err != nil {
+		return fmt.Errorf("could not get migrations status: %s", err)
+	}
+
+	pending := migrations.FilterStatuses(statuses, migrations.StatePending)
+	drifted := migrations.FilterStatuses(statuses, migrations.StateModified, migrations.StateMissing)
+
+	if err := p.result(checkOutput{
+		OK:      len(pending) == 0 && len(drifted) == 0,
+		Pending: nonNilStatuses(pending),
+		Drifted: nonNilStatuses(drifted),
+	}); err != nil {
+		return err
+	}
+
+	if len(drifted) != 0 {
+		return &exitError{exitDrift, fmt.Errorf("%d executed migrations do not match source", len(drifted))}
+	}
+	if len(pending) != 0 {
+		return &exitError{exitPending, fmt.Errorf("%d migrations are pending", len(pending))}
+	}
+
+	logger.Println("Database is up to date")
+	return nil
+}
+
+func cmdServe(addr string, u *migrations.Upgrader, logger *logrus.Logger) error {
+	mux := http.NewServeMux()
+	mux.Handle("/status", &migrations.StatusHandler{Upgrader: u, MaxAge: 5 * time.Second})
+
+	srv := http.Server{
+		Addr:    addr,
+		Handler: mux,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- srv.ListenAndServe()
+	}()
+
+	logger.WithField("addr", addr).Println("Serving migrations status on /status")
+
+	select {
+	case err := <-errs:
+		return fmt.Errorf("server failed: %s", err)
+	case <-ctx.Done():
+	}
+
+	logger.Println("Shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return srv.Shutdown(shutdownCtx)
+}
+
+// parseSource returns source of migrations of dir by spec, git:REV reads them at git revision.
+func parseSource(spec, dir string, opts migrations.SourceFS) (migrations.Source, error) {
+	if strings.HasPrefix(spec, "git:") {
+		return &migrations.SourceGit{Dir: dir, Rev: strings.TrimPrefix(spec, "git:"), Options: opts}, nil
+	}
+	return nil, fmt.Errorf("unknown source %s, expected git:REV", spec)
+}
+
+func sourceDir(dir string, opts migrations.SourceFS) *migrations.SourceDir {
+	return &migrations.SourceDir{
+		Dir:             dir,
+		Vars:            opts.Vars,
+		Patterns:        opts.Patterns,
+		NonRecursive:    opts.NonRecursive,
+		IDScheme:        opts.IDScheme,
+		StripExtension:  opts.StripExtension,
+		StreamThreshold: opts.StreamThreshold,
+	}
+}
+
+// modulesSource merges migrations of modules directories, read at revision of spec when it is set.
+// Modules are in order of their names.
+func modulesSource(a args, spec string, opts migrations.SourceFS) (migrations.Source, error) {
+	order, err := parseMergeOrder(a.mergeOrder)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(a.modules))
+	for name := range a.modules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	multi := migrations.SourceMulti{Namespace: a.namespaceModules, Order: order}
+	for _, name := range names {
+		var src migrations.Source = sourceDir(a.modules[name], opts)
+		if spec != "" {
+			if src, err = parseSource(spec, a.modules[name], opts); err != nil {
+				return nil, err
+			}
+		}
+		multi.Modules = append(multi.Modules, migrations.SourceModule{Name: name, Source: src})
+	}
+	return &multi, nil
+}
+
+func parseMergeOrder(value string) (migrations.MergeOrder, error) {
+	switch value {
+	case "version":
+		return migrations.MergeByVersion, nil
+	case "source":
+		return migrations.MergeBySource, nil
+	}
+	return 0, fmt.Errorf("unknown merge order: %s, expected version or source", value)
+}
+
+func parseIDScheme(value string) (migrations.IDScheme, error) {
+	switch value {
+	case "base":
+		return migrations.IDBaseName, nil
+	case "path":
+		return migrations.IDRelPath, nil
+	}
+	return 0, fmt.Errorf("unknown id scheme: %s, expected base or path", value)
+}
+
+// nonNilStatuses makes empty lists encode as [] instead of null.
+func nonNilStatuses(statuses []migrations.MigrationStatus) []migrations.MigrationStatus {
+	if statuses == nil {
+		return []migrations.MigrationStatus{}
+	}
+	return statuses
+}
+
+func openPostgres(dsn string, wait migrations.Backoff, logger *logrus.Logger) (*sql.DB, error) {
 	db, err := sql.Open("postgres", dsn)
 	if err != nil {
 		return nil, fmt.Errorf("could not open postgres: %s", err)
 	}
 
-	if err := db.Ping(); err != nil {
+	if wait.MaxWait > 0 {
+		err = migrations.WaitForDB(context.Background(), db, wait, &migrations.LoggerLogrus{Logger: logger})
+	} else {
+		err = db.Ping()
+	}
+	if err != nil {
 		return db, fmt.Errorf("failed to ping postgres: %s", err)
 	}
 
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	migrations "github.com/ulexxander/go-db-migrations"
+	"gopkg.in/yaml.v3"
+)
+
+// Output formats.
+const (
+	outputText = "text"
+	outputJSON = "json"
+	outputYAML = "yaml"
+	// outputTable is former name of outputText, still accepted.
+	outputTable = "table"
+)
+
+// Exit codes, allowing CI to distinguish failures from detected problems.
+const (
+	exitFailure = 1
+	exitPending = 2
+	exitDrift   = 3
+)
+
+// exitError makes process exit with specific code.
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string {
+	return e.err.Error()
+}
+
+// printer writes command results to stdout in json output mode,
+// in text mode results are logged by commands themselves.
+type printer struct {
+	output  string
+	w       io.Writer
+	printed bool
+}
+
+func (p *printer) json() bool {
+	return p.output == outputJSON
+}
+
+// result prints command result if output is not text.
+func (p *printer) result(v interface{}) error {
+	switch p.output {
+	case outputText:
+		return nil
+	case outputJSON:
+		p.printed = true
+		enc := json.NewEncoder(p.w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	case outputYAML:
+		p.printed = true
+		return yaml.NewEncoder(p.w).Encode(v)
+	default:
+		return fmt.Errorf("unknown output format: %s", p.output)
+	}
+}
+
+// Command results printed in json output mode.
+// Fields must not be renamed or removed, scripts depend on them.
+
+type errorOutput struct {
+	Error string `json:"error" yaml:"error"`
+}
+
+type statusOutput struct {
+	Migrations []migrations.MigrationStatus `json:"migrations" yaml:"migrations"`
+	// Outstanding are phases having pending migrations.
+	Outstanding []string `json:"outstanding_phases" yaml:"outstanding_phases"`
+}
+
+type upgradeOutput struct {
+	Executed []string `json:"executed" yaml:"executed"`
+	Error    string   `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+type recordOutput struct {
+	ID string `json:"id" yaml:"id"`
+}
+
+type baselineOutput struct {
+	Baselined []string `json:"baselined" yaml:"baselined"`
+}
+
+type rewriteIDsOutput struct {
+	// Renamed are new ids by old ones.
+	Renamed map[string]string `json:"renamed" yaml:"renamed"`
+}
+
+type bundleOutput struct {
+	File       string `json:"file" yaml:"file"`
+	Version    string `json:"version" yaml:"version"`
+	Migrations int    `json:"migrations" yaml:"migrations"`
+}
+
+type diffOutput struct {
+	Added    []string `json:"added" yaml:"added"`
+	Removed  []string `json:"removed" yaml:"removed"`
+	Modified []string `json:"modified" yaml:"modified"`
+}
+
+type squashOutput struct {
+	File     string   `json:"file" yaml:"file"`
+	Squashes []string `json:"squashes" yaml:"squashes"`
+}
+
+type createOutput struct {
+	Files []string `json:"files" yaml:"files"`
+}
+
+type plannedMigration struct {
+	ID         string `json:"id" yaml:"id"`
+	Repeatable bool   `json:"repeatable,omitempty" yaml:"repeatable,omitempty"`
+	Phase      string `json:"phase,omitempty" yaml:"phase,omitempty"`
+	Checksum   string `json:"checksum" yaml:"checksum"`
+}
+
+type planOutput struct {
+	Pending []plannedMigration `json:"pending" yaml:"pending"`
+	// Branches are IDs of pending versioned migrations
+	// grouped into independent branches, see Upgrader.Branches.
+	Branches [][]string `json:"branches" yaml:"branches"`
+}
+
+type validateOutput struct {
+	Valid    bool                         `json:"valid" yaml:"valid"`
+	Problems []migrations.MigrationStatus `json:"problems" yaml:"problems"`
+}
+
+type checkOutput struct {
+	OK      bool                         `json:"ok" yaml:"ok"`
+	Pending []migrations.MigrationStatus `json:"pending" yaml:"pending"`
+	Drifted []migrations.MigrationStatus `json:"drifted" yaml:"drifted"`
+}
+
+type tenantResult struct {
+	Schema     string   `json:"schema" yaml:"schema"`
+	Executed   []string `json:"executed" yaml:"executed"`
+	DurationMS int64    `json:"duration_ms" yaml:"duration_ms"`
+	Skipped    bool     `json:"skipped,omitempty" yaml:"skipped,omitempty"`
+	Error      string   `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+type tenantsOutput struct {
+	Tenants   []tenantResult `json:"tenants" yaml:"tenants"`
+	Succeeded int            `json:"succeeded" yaml:"succeeded"`
+	Failed    int            `json:"failed" yaml:"failed"`
+	Skipped   int            `json:"skipped" yaml:"skipped"`
+	Error     string         `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+func newTenantsOutput(results []migrations.TargetResult) tenantsOutput {
+	summary := migrations.Summarize(results)
+	out := tenantsOutput{
+		Tenants:   []tenantResult{},
+		Succeeded: summary.Succeeded,
+		Failed:    summary.Failed,
+		Skipped:   summary.Skipped,
+	}
+	for _, r := range results {
+		tr := tenantResult{
+			Schema:     r.Target,
+			Executed:   []string{},
+			DurationMS: r.Duration.Milliseconds(),
+			Skipped:    errors.Is(r.Err, migrations.ErrSkipped),
+		}
+		if r.Result != nil {
+			tr.Executed = migrationIDs(r.Result.Executed)
+		}
+		if r.Err != nil && !tr.Skipped {
+			tr.Error = r.Err.Error()
+		}
+		out.Tenants = append(out.Tenants, tr)
+	}
+	return out
+}
+
+type shardResult struct {
+	Shard      string   `json:"shard" yaml:"shard"`
+	Executed   []string `json:"executed" yaml:"executed"`
+	DurationMS int64    `json:"duration_ms" yaml:"duration_ms"`
+	Skipped    bool     `json:"skipped,omitempty" yaml:"skipped,omitempty"`
+	Error      string   `json:"error,omitempty" yaml:"error,omitempty"`
+	// Missing are migrations executed in other shards, but not in this one.
+	Missing []string `json:"missing,omitempty" yaml:"missing,omitempty"`
+}
+
+type shardsOutput struct {
+	Shards    []shardResult `json:"shards" yaml:"shards"`
+	Succeeded int           `json:"succeeded" yaml:"succeeded"`
+	Failed    int           `json:"failed" yaml:"failed"`
+	Skipped   int           `json:"skipped" yaml:"skipped"`
+	Divergent int           `json:"divergent" yaml:"divergent"`
+	Error     string        `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+func newShardsOutput(report *migrations.ShardsReport) shardsOutput {
+	out := shardsOutput{Shards: []shardResult{}}
+	if report == nil {
+		return out
+	}
+
+	tenants := newTenantsOutput(report.Results)
+	out.Succeeded = tenants.Succeeded
+	out.Failed = tenants.Failed
+	out.Skipped = tenants.Skipped
+	out.Divergent = len(report.Divergent)
+
+	missing := map[string][]string{}
+	for _, d := range report.Divergent {
+		missing[d.Shard] = d.Missing
+	}
+	for _, t := range tenants.Tenants {
+		out.Shards = append(out.Shards, shardResult{
+			Shard:      t.Schema,
+			Executed:   t.Executed,
+			DurationMS: t.DurationMS,
+			Skipped:    t.Skipped,
+			Error:      t.Error,
+			Missing:    missing[t.Schema],
+		})
+	}
+	return out
+}
+
+func migrationIDs(migs []migrations.Migration) []string {
+	ids := make([]string, 0, len(migs))
+	for _, mig := range migs {
+		ids = append(ids, mig.ID)
+	}
+	return ids
+}
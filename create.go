@@ -0,0 +1,185 @@
+package migrations
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Versioning schemes of Creator.
+const (
+	VersionTimestamp = "timestamp"
+	VersionSequence  = "sequence"
+)
+
+const timestampVersionLayout = "20060102150405"
+
+// DefaultTemplate is used by Creator when Template is empty.
+const DefaultTemplate = `-- {{.Name}} ({{.Direction}})
+-- Created at {{.CreatedAt.Format "2006-01-02 15:04:05"}}
+
+`
+
+// downSuffix marks files with rollback statements,
+// they are created for reference only and never executed.
+const downSuffix = ".down.sql"
+
+// TemplateData is passed to Creator template.
+type TemplateData struct {
+	ID      string
+	Version string
+	Name    string
+	// Direction is "up" or "down"
+	Direction string
+	CreatedAt time.Time
+}
+
+// Creator scaffolds new migration files in directory.
+type Creator struct {
+	Dir string
+	// Versioning is VersionTimestamp (default) or VersionSequence.
+	Versioning string
+	// Down creates paired .up.sql and .down.sql files.
+	Down bool
+	// Template is text/template of file content, see TemplateData.
+	Template string
+	// Now is used for timestamp versions, defaults to time.Now.
+	Now func() time.Time
+	// Patterns match names of existing migration files, see SourceFS.Patterns.
+	// Other files, like README.md, are skipped.
+	Patterns []string
+}
+
+var nameInvalidChars = regexp.MustCompile(`[^a-z0-9_]+`)
+
+var versionPrefix = regexp.MustCompile(`^[0-9]+`)
+
+// Create creates migration files for given name and returns their paths.
+// It refuses to create version that already exists in directory.
+func (c *Creator) Create(name string) ([]string, error) {
+	name = strings.Trim(nameInvalidChars.ReplaceAllString(strings.ToLower(name), "_"), "_")
+	if name == "" {
+		return nil, fmt.Errorf("migration name cannot be empty")
+	}
+
+	tmpl, err := template.New("migration").Parse(c.template())
+	if err != nil {
+		return nil, fmt.Errorf("could not parse template: %s", err)
+	}
+
+	versions, err := c.versions()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if c.Now != nil {
+		now = c.Now()
+	}
+
+	var version string
+	switch c.Versioning {
+	case VersionTimestamp, "":
+		version = now.UTC().Format(timestampVersionLayout)
+	case VersionSequence:
+		version = nextSequence(versions)
+	default:
+		return nil, fmt.Errorf("unknown versioning: %s", c.Versioning)
+	}
+
+	if _, ok := versions[version]; ok {
+		return nil, fmt.Errorf("migration with version %s already exists", version)
+	}
+
+	base := version + "_" + name
+	files := map[string]string{"up": base + ".sql"}
+	if c.Down {
+		files = map[string]string{
+			"up":   base + ".up.sql",
+			"down": base + downSuffix,
+		}
+	}
+
+	var paths []string
+	for _, direction := range []string{"up", "down"} {
+		filename, ok := files[direction]
+		if !ok {
+			continue
+		}
+
+		var content bytes.Buffer
+		if err := tmpl.Execute(&content, TemplateData{
+			ID:        filename,
+			Version:   version,
+			Name:      name,
+			Direction: direction,
+			CreatedAt: now,
+		}); err != nil {
+			return nil, fmt.Errorf("could not execute template: %s", err)
+		}
+
+		filePath := filepath.Join(c.Dir, filename)
+		f, err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("could not create file %s: %s", filePath, err)
+		}
+		_, err = f.Write(content.Bytes())
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("could not write file %s: %s", filePath, err)
+		}
+
+		paths = append(paths, filePath)
+	}
+
+	return paths, nil
+}
+
+func (c *Creator) template() string {
+	if c.Template == "" {
+		return DefaultTemplate
+	}
+	return c.Template
+}
+
+// versions returns numeric prefixes of existing migration files.
+func (c *Creator) versions() (map[string]struct{}, error) {
+	src := SourceDir{Dir: c.Dir, Patterns: c.Patterns}
+	paths, err := src.sourceFS().walkPaths(false)
+	if err != nil {
+		return nil, fmt.Errorf("could not get migration files paths: %s", err)
+	}
+
+	versions := map[string]struct{}{}
+	for _, p := range paths {
+		if v := versionPrefix.FindString(path.Base(p)); v != "" {
+			versions[v] = struct{}{}
+		}
+	}
+	return versions, nil
+}
+
+// nextSequence returns version following the highest one,
+// keeping zero padding of existing versions.
+func nextSequence(versions map[string]struct{}) string {
+	width := 3
+	var max uint64
+	for v := range versions {
+		n, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			continue
+		}
+		if n >= max {
+			max = n
+			width = len(v)
+		}
+	}
+	return fmt.Sprintf("%0*d", width, max+1)
+}
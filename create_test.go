@@ -0,0 +1,108 @@
+package migrations_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	migrations "github.com/ulexxander/go-db-migrations"
+)
+
+func TestCreatorSequence(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "0009_first.sql"), nil, 0644); err != nil {
+		t.Fatalf("could not create migration file: %s", err)
+	}
+
+	c := migrations.Creator{
+		Dir:        dir,
+		Versioning: migrations.VersionSequence,
+		Down:       true,
+	}
+
+	paths, err := c.Create("Add users-email")
+	if err != nil {
+		t.Fatalf("unexpected create error: %s", err)
+	}
+
+	expected := []string{
+		filepath.Join(dir, "0010_add_users_email.up.sql"),
+		filepath.Join(dir, "0010_add_users_email.down.sql"),
+	}
+	if strings.Join(paths, " ") != strings.Join(expected, " ") {
+		t.Fatalf("expected paths %v, got: %v", expected, paths)
+	}
+
+	down, err := os.ReadFile(paths[1])
+	if err != nil {
+		t.Fatalf("could not read down file: %s", err)
+	}
+	if !strings.HasPrefix(string(down), "-- add_users_email (down)") {
+		t.Errorf("unexpected down file content: %s", down)
+	}
+
+	src := migrations.SourceDir{Dir: dir}
+	migs, err := src.Migrations()
+	if err != nil {
+		t.Fatalf("unexpected source error: %s", err)
+	}
+	if len(migs) != 2 {
+		t.Fatalf("expected down file not to be a migration, got: %d migrations", len(migs))
+	}
+}
+
+func TestCreatorTimestamp(t *testing.T) {
+	dir := t.TempDir()
+
+	c := migrations.Creator{
+		Dir:      dir,
+		Template: "-- {{.ID}}\n",
+		Now: func() time.Time {
+			return time.Date(2021, 10, 25, 12, 30, 0, 0, time.UTC)
+		},
+	}
+
+	paths, err := c.Create("init")
+	if err != nil {
+		t.Fatalf("unexpected create error: %s", err)
+	}
+	if len(paths) != 1 || filepath.Base(paths[0]) != "20211025123000_init.sql" {
+		t.Fatalf("unexpected paths: %v", paths)
+	}
+
+	content, err := os.ReadFile(paths[0])
+	if err != nil {
+		t.Fatalf("could not read file: %s", err)
+	}
+	if string(content) != "-- 20211025123000_init.sql\n" {
+		t.Errorf("unexpected content: %s", content)
+	}
+
+	if _, err := c.Create("another"); err == nil {
+		t.Fatalf("expected to get error for existing version, got nil")
+	}
+}
+
+func TestCreatorSkipsOtherFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"README.md", "0003_init.pgsql", "0100_old.sql.bak"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatalf("could not create file: %s", err)
+		}
+	}
+
+	c := migrations.Creator{
+		Dir:        dir,
+		Versioning: migrations.VersionSequence,
+		Patterns:   []string{"*.pgsql"},
+	}
+	paths, err := c.Create("users")
+	if err != nil {
+		t.Fatalf("unexpected create error: %s", err)
+	}
+	if expected := filepath.Join(dir, "0004_users.sql"); len(paths) != 1 || paths[0] != expected {
+		t.Errorf("expected path %s, got: %v", expected, paths)
+	}
+}
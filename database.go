@@ -1,9 +1,12 @@
 package migrations
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/lib/pq"
@@ -13,6 +16,12 @@ type Executed struct {
 	ID         string
 	DurationMS int
 	ExecutedAt time.Time
+	// Checksum is empty for migrations recorded without content (e.g. forced).
+	Checksum string
+	// Baseline is true for migrations marked as executed without running them.
+	Baseline bool
+	// Tags of migration when it was executed.
+	Tags []string
 }
 
 type Database interface {
@@ -23,36 +32,211 @@ type Database interface {
 	IsAlreadyExecuted(id string) (bool, error)
 }
 
+// ExecutionRecorder is optionally implemented by Database to record checksum
+// and tags of executed migrations, RecordMigration is used otherwise.
+type ExecutionRecorder interface {
+	// RecordExecution should record repeatable migrations in their history,
+	// each time they are executed, and others only once
+	RecordExecution(mig Migration, duration time.Duration) error
+}
+
+// RepeatableDatabase is implemented by Database supporting repeatable migrations.
+type RepeatableDatabase interface {
+	// RepeatableMigrations should return last execution of each repeatable migration
+	RepeatableMigrations() ([]Executed, error)
+}
+
+// Baseliner is optionally implemented by Database to record migrations
+// as executed in single transaction, they are recorded one by one otherwise.
+type Baseliner interface {
+	Baseline(migs []Migration) error
+}
+
 type DatabasePostgres struct {
 	DB *sql.DB
+	// Schema keeps migrations tables and is search_path of executed migrations,
+	// followed by public schema for shared extensions and functions.
+	// It is created when missing. Default search_path is used when empty.
+	Schema string
+
+	// tablesMu guards tablesReady, set once migrations tables are created and up to date,
+	// which is done before first write, reads never alter tables
+	tablesMu    sync.Mutex
+	tablesReady bool
 }
 
 const migrationsExecutedTable = "migrations_executed"
+const migrationsRepeatableTable = "migrations_repeatable"
+
+// queries below are formatted with (schema qualified) table name, see DatabasePostgres.query
 
-var createTableQuery = fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+const createTableQuery = `CREATE TABLE IF NOT EXISTS %s (
 	id text PRIMARY KEY,
 	duration_ms int NOT NULL,
 	executed_at timestamptz NOT NULL DEFAULT NOW()
-)`, migrationsExecutedTable)
+)`
+
+// columns added after the table was introduced,
+// databases with older table get them on the next run
+const alterTableQuery = `ALTER TABLE %s
+ADD COLUMN IF NOT EXISTS checksum text NOT NULL DEFAULT '',
+ADD COLUMN IF NOT EXISTS baseline boolean NOT NULL DEFAULT false,
+ADD COLUMN IF NOT EXISTS tags text[] NOT NULL DEFAULT '{}'`
+
+// columns added later are in alterRepeatableTableQuery too
+const createRepeatableTableQuery = `CREATE TABLE IF NOT EXISTS %s (
+	id text NOT NULL,
+	checksum text NOT NULL,
+	duration_ms int NOT NULL,
+	executed_at timestamptz NOT NULL DEFAULT NOW(),
+	baseline boolean NOT NULL DEFAULT false
+)`
+
+const alterRepeatableTableQuery = `ALTER TABLE %s
+ADD COLUMN IF NOT EXISTS baseline boolean NOT NULL DEFAULT false,
+ADD COLUMN IF NOT EXISTS tags text[] NOT NULL DEFAULT '{}'`
+
+// legacyColumns are missing in tables created by older versions,
+// with values of records made before they were added
+var legacyColumns = []struct{ name, value string }{
+	{"checksum", "''"},
+	{"baseline", "false"},
+	{"tags", "'{}'::text[]"},
+}
+
+const selectColumnsQuery = `SELECT column_name FROM information_schema.columns
+WHERE table_schema = COALESCE(NULLIF($1, ''), current_schema()) AND table_name = $2`
+
+const executedColumns = "id, duration_ms, executed_at, checksum, baseline, tags"
 
-var selectExecutedMigrationsAllQuery = fmt.Sprintf(`SELECT * FROM %s
-ORDER BY executed_at DESC`, migrationsExecutedTable)
+const selectExecutedMigrationsAllQuery = `SELECT ` + executedColumns + ` FROM %s
+ORDER BY executed_at DESC`
 
-var selectExecutedMigrationQuery = fmt.Sprintf(`SELECT * FROM %s
-WHERE id = $1`, migrationsExecutedTable)
+const selectExecutedMigrationQuery = `SELECT ` + executedColumns + ` FROM %s
+WHERE id = $1`
 
-var insertMigrationQuery = fmt.Sprintf(`INSERT INTO %s (id, duration_ms)
-VALUES ($1, $2)`, migrationsExecutedTable)
+const selectRepeatableMigrationsLastQuery = `SELECT * FROM (
+	SELECT DISTINCT ON (id) ` + executedColumns + ` FROM %s
+	ORDER BY id, executed_at DESC
+) last ORDER BY executed_at DESC`
+
+const insertMigrationQuery = `INSERT INTO %s (id, duration_ms, checksum, tags)
+VALUES ($1, $2, $3, $4)`
+
+const insertBaselineQuery = `INSERT INTO %s (id, duration_ms, checksum, baseline, tags)
+VALUES ($1, 0, $2, true, $3)`
+
+const renameMigrationQuery = `UPDATE %s SET id = $2 WHERE id = $1`
+
+const insertRepeatableQuery = `INSERT INTO %s (id, duration_ms, checksum, tags)
+VALUES ($1, $2, $3, $4)`
+
+// query formats query template with table name qualified by Schema.
+func (dp *DatabasePostgres) query(tmpl, table string) string {
+	return fmt.Sprintf(tmpl, dp.qualified(table))
+}
+
+func (dp *DatabasePostgres) qualified(table string) string {
+	if dp.Schema != "" {
+		return pq.QuoteIdentifier(dp.Schema) + "." + table
+	}
+	return table
+}
 
 func (dp *DatabasePostgres) ExecutedMigrations() ([]Executed, error) {
-	_, err := dp.DB.Exec(createTableQuery)
+	from, err := dp.readTable(migrationsExecutedTable)
+	if err != nil || from == "" {
+		return nil, err
+	}
+	return dp.selectExecuted(fmt.Sprintf(selectExecutedMigrationsAllQuery, from), migrationsExecutedTable)
+}
+
+func (dp *DatabasePostgres) RepeatableMigrations() ([]Executed, error) {
+	from, err := dp.readTable(migrationsRepeatableTable)
+	if err != nil || from == "" {
+		return nil, err
+	}
+	return dp.selectExecuted(fmt.Sprintf(selectRepeatableMigrationsLastQuery, from), migrationsRepeatableTable)
+}
+
+// readTable returns FROM expression reading migrations table without altering it,
+// with values of columns missing in older table, or empty string when table does not exist.
+func (dp *DatabasePostgres) readTable(table string) (string, error) {
+	dp.tablesMu.Lock()
+	ready := dp.tablesReady
+	dp.tablesMu.Unlock()
+	if ready {
+		return dp.qualified(table), nil
+	}
+
+	rows, err := dp.DB.Query(selectColumnsQuery, dp.Schema, table)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create %s if not exists: %s", migrationsExecutedTable, err)
+		return "", fmt.Errorf("could not select columns of %s: %w", table, err)
+	}
+	defer rows.Close()
+	columns := map[string]bool{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return "", fmt.Errorf("could not scan column of %s: %w", table, err)
+		}
+		columns[name] = true
+	}
+	if err := rows.Err(); err != nil {
+		return "", fmt.Errorf("could not select columns of %s: %w", table, err)
+	}
+	if len(columns) == 0 {
+		return "", nil
+	}
+
+	exprs := []string{"*"}
+	for _, c := range legacyColumns {
+		if !columns[c.name] {
+			exprs = append(exprs, c.value+" AS "+c.name)
+		}
+	}
+	if len(exprs) == 1 {
+		return dp.qualified(table), nil
+	}
+	return fmt.Sprintf("(SELECT %s FROM %s) %s", strings.Join(exprs, ", "), dp.qualified(table), table), nil
+}
+
+// ensureTables creates migrations tables or adds missing columns once.
+func (dp *DatabasePostgres) ensureTables() error {
+	dp.tablesMu.Lock()
+	defer dp.tablesMu.Unlock()
+	if dp.tablesReady {
+		return nil
+	}
+	if err := dp.createTables(); err != nil {
+		return err
 	}
+	dp.tablesReady = true
+	return nil
+}
 
-	rows, err := dp.DB.Query(selectExecutedMigrationsAllQuery)
+func (dp *DatabasePostgres) createTables() error {
+	queries := []string{
+		dp.query(createTableQuery, migrationsExecutedTable),
+		dp.query(alterTableQuery, migrationsExecutedTable),
+		dp.query(createRepeatableTableQuery, migrationsRepeatableTable),
+		dp.query(alterRepeatableTableQuery, migrationsRepeatableTable),
+	}
+	if dp.Schema != "" {
+		queries = append([]string{"CREATE SCHEMA IF NOT EXISTS " + pq.QuoteIdentifier(dp.Schema)}, queries...)
+	}
+	for _, q := range queries {
+		if _, err := dp.DB.Exec(q); err != nil {
+			return fmt.Errorf("failed to create migrations tables if not exist: %w", err)
+		}
+	}
+	return nil
+}
+
+func (dp *DatabasePostgres) selectExecuted(query, table string) ([]Executed, error) {
+	rows, err := dp.DB.Query(query)
 	if err != nil {
-		return nil, fmt.Errorf("failed to select %s: %s", migrationsExecutedTable, err)
+		return nil, fmt.Errorf("failed to select %s: %w", table, err)
 	}
 	defer rows.Close()
 
@@ -63,52 +247,176 @@ func (dp *DatabasePostgres) ExecutedMigrations() ([]Executed, error) {
 			&item.ID,
 			&item.DurationMS,
 			&item.ExecutedAt,
+			&item.Checksum,
+			&item.Baseline,
+			pq.Array(&item.Tags),
 		); err != nil {
-			return nil, fmt.Errorf("failed to scan executed migration: %s", err)
+			return nil, fmt.Errorf("failed to scan executed migration: %w", err)
 		}
 		result = append(result, item)
 	}
 
 	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("failed to select %s (rows containing error): %s", migrationsExecutedTable, err)
+		return nil, fmt.Errorf("failed to select %s (rows containing error): %w", table, err)
 	}
 
 	return result, nil
 }
 
 func (dp *DatabasePostgres) RecordMigration(id string, duration time.Duration) error {
-	_, err := dp.DB.Exec(insertMigrationQuery, id, duration.Milliseconds())
+	return dp.RecordExecution(Migration{ID: id}, duration)
+}
+
+func (dp *DatabasePostgres) RecordExecution(mig Migration, duration time.Duration) error {
+	if err := dp.ensureTables(); err != nil {
+		return err
+	}
+	if mig.Repeatable {
+		_, err := dp.DB.Exec(dp.query(insertRepeatableQuery, migrationsRepeatableTable), mig.ID, duration.Milliseconds(), mig.Checksum, pq.Array(migrationTags(mig)))
+		if err != nil {
+			return fmt.Errorf("could not insert record in %s: %w", migrationsRepeatableTable, err)
+		}
+		return nil
+	}
+
+	_, err := dp.DB.Exec(dp.query(insertMigrationQuery, migrationsExecutedTable), mig.ID, duration.Milliseconds(), mig.Checksum, pq.Array(migrationTags(mig)))
 	if err != nil {
 		if isPrimaryKeyErr(err) {
-			return fmt.Errorf("migration %s is already executed", id)
+			return fmt.Errorf("migration %s is already executed", mig.ID)
 		}
-		return fmt.Errorf("could not insert record in migrations_executed: %s", err)
+		return fmt.Errorf("could not insert record in %s: %w", migrationsExecutedTable, err)
 	}
 	return nil
 }
 
+func (dp *DatabasePostgres) Baseline(migs []Migration) error {
+	if err := dp.ensureTables(); err != nil {
+		return err
+	}
+	tx, err := dp.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %w", err)
+	}
+
+	for _, mig := range migs {
+		if _, err := tx.Exec(dp.query(insertBaselineQuery, migrationsExecutedTable), mig.ID, mig.Checksum, pq.Array(migrationTags(mig))); err != nil {
+			tx.Rollback()
+			if isPrimaryKeyErr(err) {
+				return fmt.Errorf("migration %s is already executed", mig.ID)
+			}
+			return fmt.Errorf("could not insert baseline record for %s: %w", mig.ID, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("could not commit baseline: %w", err)
+	}
+	return nil
+}
+
+// Migrate executes migration in transaction, streamed migrations are read
+// as they are executed and their COPY data is loaded row by row, see SourceFS.StreamThreshold.
+// Migrations tables are created before, so that missing privileges fail it early.
 func (dp *DatabasePostgres) Migrate(mig Migration) error {
+	if err := dp.ensureTables(); err != nil {
+		return err
+	}
 	tx, err := dp.DB.Begin()
 	if err != nil {
-		return fmt.Errorf("could not begin transaction: %s", err)
+		return fmt.Errorf("could not begin transaction: %w", err)
+	}
+
+	if dp.Schema != "" {
+		if _, err := tx.Exec("SET LOCAL search_path TO " + pq.QuoteIdentifier(dp.Schema) + ", public"); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("could not set search_path: %w", err)
+		}
 	}
 
-	if _, err := tx.Exec(mig.Content); err != nil {
+	if mig.Open != nil {
+		err = dp.migrateStream(tx, mig)
+	} else {
+		_, err = tx.Exec(mig.Content)
+	}
+	if err != nil {
 		tx.Rollback()
 		return err
 	}
 
-	tx.Commit()
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("could not commit transaction: %w", err)
+	}
+	return nil
+}
+
+// RenameMigrations changes IDs of executed migrations in single transaction,
+// renames are new IDs by old ones, see SourceFS.IDRenames.
+// Renames can swap IDs or chain them, like a to b and b to c.
+func (dp *DatabasePostgres) RenameMigrations(renames map[string]string) error {
+	oldIDs := sortedRenames(renames)
+	renamedTo := map[string]string{}
+	for _, oldID := range oldIDs {
+		if other, ok := renamedTo[renames[oldID]]; ok {
+			return fmt.Errorf("migrations %s and %s can not be both renamed to %s", other, oldID, renames[oldID])
+		}
+		renamedTo[renames[oldID]] = oldID
+	}
+
+	if err := dp.ensureTables(); err != nil {
+		return err
+	}
+
+	tx, err := dp.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %w", err)
+	}
+
+	// migrations are renamed through temporary IDs first, so that new ID
+	// of one can be old ID of other without violating primary key
+	tmpIDs := make(map[string]string, len(oldIDs))
+	for i, oldID := range oldIDs {
+		tmpIDs[oldID] = fmt.Sprintf("renaming/%d/%s", i, oldID)
+	}
+	for _, oldID := range oldIDs {
+		for _, table := range []string{migrationsExecutedTable, migrationsRepeatableTable} {
+			if _, err := tx.Exec(dp.query(renameMigrationQuery, table), oldID, tmpIDs[oldID]); err != nil {
+				tx.Rollback()
+				return fmt.Errorf("could not rename migration %s in %s: %w", oldID, table, err)
+			}
+		}
+	}
+	for _, oldID := range oldIDs {
+		for _, table := range []string{migrationsExecutedTable, migrationsRepeatableTable} {
+			if _, err := tx.Exec(dp.query(renameMigrationQuery, table), tmpIDs[oldID], renames[oldID]); err != nil {
+				tx.Rollback()
+				if isPrimaryKeyErr(err) {
+					return fmt.Errorf("could not rename migration %s, %s is already executed", oldID, renames[oldID])
+				}
+				return fmt.Errorf("could not rename migration %s in %s: %w", oldID, table, err)
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("could not commit renames: %w", err)
+	}
 	return nil
 }
 
 func (dp *DatabasePostgres) IsAlreadyExecuted(id string) (bool, error) {
-	row := dp.DB.QueryRow(selectExecutedMigrationQuery, id)
+	from, err := dp.readTable(migrationsExecutedTable)
+	if err != nil || from == "" {
+		return false, err
+	}
+	row := dp.DB.QueryRow(fmt.Sprintf(selectExecutedMigrationQuery, from), id)
 	var executed Executed
-	err := row.Scan(
+	err = row.Scan(
 		&executed.ID,
 		&executed.DurationMS,
 		&executed.ExecutedAt,
+		&executed.Checksum,
+		&executed.Baseline,
+		pq.Array(&executed.Tags),
 	)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
@@ -119,6 +427,47 @@ func (dp *DatabasePostgres) IsAlreadyExecuted(id string) (bool, error) {
 	return true, nil
 }
 
+// advisoryLockKey identifies postgres advisory lock held during upgrades.
+const advisoryLockKey int64 = 7267123749461
+
+// LockPostgres acquires session advisory lock, waiting while another process holds it,
+// so that concurrently starting instances do not run migrations at the same time.
+// Returned function releases the lock.
+// Lock is held on its own connection while migrations are executed on others,
+// so pool limited to single connection is an error, it would deadlock.
+func LockPostgres(ctx context.Context, db *sql.DB) (unlock func() error, err error) {
+	if db.Stats().MaxOpenConnections == 1 {
+		return nil, errors.New("advisory lock requires more than single open connection, see sql.DB.SetMaxOpenConns")
+	}
+	// session lock belongs to connection, so it has to be released on the same one
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("could not get connection: %w", err)
+	}
+
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", advisoryLockKey); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("could not acquire advisory lock: %w", err)
+	}
+
+	unlock = func() error {
+		defer conn.Close()
+		if _, err := conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", advisoryLockKey); err != nil {
+			return fmt.Errorf("could not release advisory lock: %w", err)
+		}
+		return nil
+	}
+	return unlock, nil
+}
+
+// migrationTags returns migration tags, never nil, as tags column is not null.
+func migrationTags(mig Migration) []string {
+	if mig.Tags == nil {
+		return []string{}
+	}
+	return mig.Tags
+}
+
 func isPrimaryKeyErr(err error) bool {
 	switch err := err.(type) {
 	case *pq.Error:
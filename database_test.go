@@ -4,6 +4,7 @@ import (
 	"database/sql"
 	"fmt"
 	"os"
+	"sort"
 	"strings"
 	"testing"
 	"time"
@@ -50,13 +51,14 @@ func openDB() (*sql.DB, error) {
 }
 
 const migrationsExecutedTable = "migrations_executed"
+const migrationsRepeatableTable = "migrations_repeatable"
 const table1 = "first"
 const table2 = "second"
 const table3 = "third"
 
 func resetDB(db *sql.DB) error {
 	var tables = []string{
-		migrationsExecutedTable, table1, table2, table3,
+		migrationsExecutedTable, migrationsRepeatableTable, table1, table2, table3,
 	}
 
 	for _, table := range tables {
@@ -149,11 +151,11 @@ func TestNotAllowingToRecordSameMigrationAgain(t *testing.T) {
 	dbp.ExecutedMigrations()
 
 	migID := "1.sql"
-	if err := dbp.RecordMigration(migID, time.Millisecond); err != nil {
+	if err := dbp.RecordExecution(migrations.Migration{ID: migID}, time.Millisecond); err != nil {
 		t.Fatalf("failed to execute migration %s for the first time: %s", migID, err)
 	}
 
-	err = dbp.RecordMigration(migID, time.Millisecond)
+	err = dbp.RecordExecution(migrations.Migration{ID: migID}, time.Millisecond)
 	if err == nil {
 		t.Fatalf("expected to get error when executing migration %s again, got nil", migID)
 	}
@@ -178,7 +180,7 @@ func TestIsAlreadyExecuted(t *testing.T) {
 	dbp.ExecutedMigrations()
 
 	migID := "abc.sql"
-	if err := dbp.RecordMigration(migID, time.Millisecond); err != nil {
+	if err := dbp.RecordExecution(migrations.Migration{ID: migID}, time.Millisecond); err != nil {
 		t.Fatalf("unexpected error when executing migration: %s", err)
 	}
 
@@ -191,6 +193,110 @@ func TestIsAlreadyExecuted(t *testing.T) {
 	}
 }
 
+func TestRecordsRepeatableHistory(t *testing.T) {
+	db, err := openDB()
+	if err != nil {
+		t.Fatalf("could not setup db: %s", err)
+	}
+	defer db.Close()
+	if err := resetDB(db); err != nil {
+		t.Fatalf("could not reset db: %s", err)
+	}
+
+	dbp := migrations.DatabasePostgres{DB: db}
+
+	// needed here to estabilish migrations tables initially
+	dbp.RepeatableMigrations()
+
+	v1 := migrations.NewMigration("R__views.sql", "CREATE VIEW v1")
+	v2 := migrations.NewMigration("R__views.sql", "CREATE OR REPLACE VIEW v1")
+	for _, mig := range []migrations.Migration{v1, v2} {
+		if err := dbp.RecordExecution(mig, time.Millisecond); err != nil {
+			t.Fatalf("failed to record repeatable migration: %s", err)
+		}
+	}
+
+	last, err := dbp.RepeatableMigrations()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	executedSliceEquals(t, last, []string{v2.ID})
+	if last[0].Checksum != v2.Checksum {
+		t.Errorf("expected last checksum to be %s, got: %s", v2.Checksum, last[0].Checksum)
+	}
+
+	executed, err := dbp.ExecutedMigrations()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if executed != nil {
+		t.Fatalf("expected repeatable migrations not to be in executed ones, got: %d", len(executed))
+	}
+
+	var history int
+	if err := db.QueryRow("SELECT COUNT(*) FROM " + migrationsRepeatableTable).Scan(&history); err != nil {
+		t.Fatalf("could not count repeatable history: %s", err)
+	}
+	if history != 2 {
+		t.Fatalf("expected 2 history records, got: %d", history)
+	}
+}
+
+func TestRecordsBaselineInTransaction(t *testing.T) {
+	db, err := openDB()
+	if err != nil {
+		t.Fatalf("could not setup db: %s", err)
+	}
+	defer db.Close()
+	if err := resetDB(db); err != nil {
+		t.Fatalf("could not reset db: %s", err)
+	}
+
+	dbp := migrations.DatabasePostgres{DB: db}
+
+	// needed here to estabilish migrations_executed table initially
+	dbp.ExecutedMigrations()
+
+	if err := dbp.RecordExecution(migrations.Migration{ID: "2.sql"}, time.Millisecond); err != nil {
+		t.Fatalf("failed to record migration: %s", err)
+	}
+
+	err = dbp.Baseline([]migrations.Migration{{ID: "1.sql"}, {ID: "2.sql"}})
+	if err == nil {
+		t.Fatalf("expected to get error when baseline contains executed migration, got nil")
+	}
+
+	executed, err := dbp.ExecutedMigrations()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(executed) != 1 {
+		t.Fatalf("expected failed baseline to be rolled back, got %d executed", len(executed))
+	}
+
+	if err := dbp.Baseline([]migrations.Migration{{ID: "0.sql"}, {ID: "1.sql"}}); err != nil {
+		t.Fatalf("unexpected baseline error: %s", err)
+	}
+
+	isExecuted, err := dbp.IsAlreadyExecuted("1.sql")
+	if err != nil {
+		t.Fatalf("unexpected error when checking if migration is executed: %s", err)
+	}
+	if !isExecuted {
+		t.Fatalf("migration should have been baselined")
+	}
+
+	executed, err = dbp.ExecutedMigrations()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	for _, e := range executed {
+		if e.Baseline != (e.ID != "2.sql") {
+			t.Errorf("unexpected baseline flag %t for %s", e.Baseline, e.ID)
+		}
+	}
+}
+
 func executedEquals(t *testing.T, e migrations.Executed, id string) {
 	t.Helper()
 	if e.ID != id {
@@ -214,3 +320,189 @@ func executedSliceEquals(t *testing.T, e []migrations.Executed, ids []string) {
 		executedEquals(t, mig, id)
 	}
 }
+
+func TestRecordsTags(t *testing.T) {
+	db, err := openDB()
+	if err != nil {
+		t.Fatalf("could not setup db: %s", err)
+	}
+	defer db.Close()
+	if err := resetDB(db); err != nil {
+		t.Fatalf("could not reset db: %s", err)
+	}
+
+	dbp := migrations.DatabasePostgres{DB: db}
+	dbp.ExecutedMigrations()
+
+	if err := dbp.RecordExecution(migrations.Migration{ID: "1.sql", Tags: []string{"seed", "dev"}}, time.Millisecond); err != nil {
+		t.Fatalf("failed to record migration: %s", err)
+	}
+	if err := dbp.RecordExecution(migrations.Migration{ID: "2.sql"}, time.Millisecond); err != nil {
+		t.Fatalf("failed to record untagged migration: %s", err)
+	}
+
+	executed, err := dbp.ExecutedMigrations()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	tags := map[string]string{}
+	for _, e := range executed {
+		tags[e.ID] = strings.Join(e.Tags, ",")
+	}
+	if tags["1.sql"] != "seed,dev" || tags["2.sql"] != "" {
+		t.Errorf("unexpected recorded tags: %v", tags)
+	}
+}
+
+func TestRenameMigrations(t *testing.T) {
+	db, err := openDB()
+	if err != nil {
+		t.Fatalf("could not setup db: %s", err)
+	}
+	defer db.Close()
+	if err := resetDB(db); err != nil {
+		t.Fatalf("could not reset db: %s", err)
+	}
+
+	dbp := migrations.DatabasePostgres{DB: db}
+	dbp.ExecutedMigrations()
+
+	for _, mig := range []migrations.Migration{{ID: "1.sql"}, {ID: "2.sql"}, {ID: "R__views.sql", Repeatable: true}} {
+		if err := dbp.RecordExecution(mig, time.Millisecond); err != nil {
+			t.Fatalf("failed to record migration: %s", err)
+		}
+	}
+
+	err = dbp.RenameMigrations(map[string]string{"1.sql": "a/1", "2.sql": "a/1"})
+	if err == nil {
+		t.Fatalf("expected error when renaming two migrations to the same id")
+	}
+	err = dbp.RenameMigrations(map[string]string{"1.sql": "2.sql"})
+	if err == nil {
+		t.Fatalf("expected error when renaming to id of other executed migration")
+	}
+	isExecuted, err := dbp.IsAlreadyExecuted("a/1")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if isExecuted {
+		t.Fatalf("expected failed renames to be rolled back")
+	}
+
+	if err := dbp.RenameMigrations(map[string]string{"1.sql": "a/1", "R__views.sql": "a/R__views"}); err != nil {
+		t.Fatalf("unexpected rename error: %s", err)
+	}
+	executed, err := dbp.ExecutedMigrations()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	repeatable, err := dbp.RepeatableMigrations()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var ids []string
+	for _, e := range append(executed, repeatable...) {
+		ids = append(ids, e.ID)
+	}
+	if got := strings.Join(ids, ","); !strings.Contains(got, "a/1") || !strings.Contains(got, "a/R__views") || strings.Contains(got, "1.sql") {
+		t.Errorf("unexpected ids after rename: %s", got)
+	}
+
+	// new ID of one migration is old ID of other
+	if err := dbp.RenameMigrations(map[string]string{"a/1": "3.sql", "2.sql": "a/1"}); err != nil {
+		t.Fatalf("unexpected rename of chained ids: %s", err)
+	}
+	if err := dbp.RenameMigrations(map[string]string{"a/1": "3.sql", "3.sql": "a/1"}); err != nil {
+		t.Fatalf("unexpected rename of swapped ids: %s", err)
+	}
+	executed, err = dbp.ExecutedMigrations()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	ids = nil
+	for _, e := range executed {
+		ids = append(ids, e.ID)
+	}
+	sort.Strings(ids)
+	if got := strings.Join(ids, ","); got != "3.sql,a/1" {
+		t.Errorf("unexpected ids after chained renames: %s", got)
+	}
+}
+
+func TestUpgradesOldRepeatableTable(t *testing.T) {
+	db, err := openDB()
+	if err != nil {
+		t.Fatalf("could not setup db: %s", err)
+	}
+	defer db.Close()
+	if err := resetDB(db); err != nil {
+		t.Fatalf("could not reset db: %s", err)
+	}
+
+	// layout of repeatable migrations table before baseline and tags columns
+	_, err = db.Exec(`CREATE TABLE migrations_repeatable (
+		id text NOT NULL,
+		checksum text NOT NULL,
+		duration_ms int NOT NULL,
+		executed_at timestamptz NOT NULL DEFAULT NOW()
+	)`)
+	if err != nil {
+		t.Fatalf("could not create old table: %s", err)
+	}
+	if _, err := db.Exec(`INSERT INTO migrations_repeatable (id, checksum, duration_ms) VALUES ('R__views.sql', 'abc', 1)`); err != nil {
+		t.Fatalf("could not insert old record: %s", err)
+	}
+
+	dbp := migrations.DatabasePostgres{DB: db}
+	executed, err := dbp.RepeatableMigrations()
+	if err != nil {
+		t.Fatalf("could not read repeatable migrations of old table: %s", err)
+	}
+	if len(executed) != 1 || executed[0].ID != "R__views.sql" || executed[0].Baseline {
+		t.Errorf("unexpected repeatable migrations: %+v", executed)
+	}
+
+	// reads do not alter tables, columns are added before first write
+	if n := countColumns(t, db, "migrations_repeatable"); n != 4 {
+		t.Errorf("expected table not to be altered by read, got %d columns", n)
+	}
+	if err := dbp.RecordExecution(migrations.Migration{ID: "R__views.sql", Checksum: "def", Repeatable: true}, time.Millisecond); err != nil {
+		t.Fatalf("could not record migration: %s", err)
+	}
+	if n := countColumns(t, db, "migrations_repeatable"); n != 6 {
+		t.Errorf("expected columns to be added before write, got %d columns", n)
+	}
+}
+
+func countColumns(t *testing.T, db *sql.DB, table string) int {
+	t.Helper()
+	var n int
+	err := db.QueryRow("SELECT count(*) FROM information_schema.columns WHERE table_schema = current_schema() AND table_name = $1", table).Scan(&n)
+	if err != nil {
+		t.Fatalf("could not count columns: %s", err)
+	}
+	return n
+}
+
+func TestReadsWithoutTables(t *testing.T) {
+	db, err := openDB()
+	if err != nil {
+		t.Fatalf("could not setup db: %s", err)
+	}
+	defer db.Close()
+	if err := resetDB(db); err != nil {
+		t.Fatalf("could not reset db: %s", err)
+	}
+
+	dbp := migrations.DatabasePostgres{DB: db}
+	executed, err := dbp.ExecutedMigrations()
+	if err != nil || len(executed) != 0 {
+		t.Fatalf("expected no executed migrations, got: %v, %v", executed, err)
+	}
+	if isExecuted, err := dbp.IsAlreadyExecuted("1.sql"); err != nil || isExecuted {
+		t.Fatalf("expected migration not to be executed, got: %v, %v", isExecuted, err)
+	}
+	if n := countColumns(t, db, "migrations_executed"); n != 0 {
+		t.Errorf("expected reads not to create tables, got %d columns", n)
+	}
+}
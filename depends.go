@@ -0,0 +1,179 @@
+package migrations
+
+import (
+	"container/heap"
+	"fmt"
+	"strings"
+)
+
+// SortByDependencies orders migrations so that each one follows its dependencies
+// (see Migration.DependsOn), otherwise keeping their source order.
+// Dependency on squashed migration is satisfied by the one squashing it.
+// It fails on dependencies missing from source and on dependency cycles.
+func SortByDependencies(migs []Migration) ([]Migration, error) {
+	index := make(map[string]int, len(migs))
+	for i, mig := range migs {
+		index[mig.ID] = i
+	}
+	for i, mig := range migs {
+		for _, id := range mig.Squashes {
+			if _, ok := index[id]; !ok {
+				index[id] = i
+			}
+		}
+	}
+
+	// dependents of each migration and count of its unsatisfied dependencies
+	dependents := make([][]int, len(migs))
+	waiting := make([]int, len(migs))
+	for i, mig := range migs {
+		seen := map[int]bool{}
+		for _, dep := range mig.DependsOn {
+			j, ok := index[dep]
+			if !ok {
+				return nil, fmt.Errorf("migration %s depends on %s which is not in source", mig.ID, dep)
+			}
+			if migs[j].Repeatable && !mig.Repeatable {
+				return nil, fmt.Errorf("migration %s can not depend on repeatable migration %s", mig.ID, dep)
+			}
+			if j == i {
+				return nil, fmt.Errorf("migration %s depends on itself", mig.ID)
+			}
+			if seen[j] {
+				continue
+			}
+			seen[j] = true
+			dependents[j] = append(dependents[j], i)
+			waiting[i]++
+		}
+	}
+
+	// ready migrations are taken in source order
+	var ready indexHeap
+	for i := range migs {
+		if waiting[i] == 0 {
+			ready = append(ready, i)
+		}
+	}
+	heap.Init(&ready)
+
+	sorted := make([]Migration, 0, len(migs))
+	for ready.Len() > 0 {
+		i := heap.Pop(&ready).(int)
+		sorted = append(sorted, migs[i])
+		for _, d := range dependents[i] {
+			waiting[d]--
+			if waiting[d] == 0 {
+				heap.Push(&ready, d)
+			}
+		}
+	}
+
+	if len(sorted) < len(migs) {
+		return nil, fmt.Errorf("dependency cycle between migrations: %s", strings.Join(findCycle(migs, index, waiting), " -> "))
+	}
+	return sorted, nil
+}
+
+// findCycle follows unsatisfied dependencies until some migration repeats.
+// Every migration left with unsatisfied dependencies has one, so cycle is always found.
+func findCycle(migs []Migration, index map[string]int, waiting []int) []string {
+	start := 0
+	for waiting[start] == 0 {
+		start++
+	}
+
+	visited := map[int]int{}
+	var path []int
+	for i := start; ; {
+		if pos, ok := visited[i]; ok {
+			var ids []string
+			for _, j := range path[pos:] {
+				ids = append(ids, migs[j].ID)
+			}
+			return append(ids, migs[i].ID)
+		}
+		visited[i] = len(path)
+		path = append(path, i)
+		for _, dep := range migs[i].DependsOn {
+			if j := index[dep]; waiting[j] > 0 {
+				i = j
+				break
+			}
+		}
+	}
+}
+
+type indexHeap []int
+
+func (h indexHeap) Len() int            { return len(h) }
+func (h indexHeap) Less(i, j int) bool  { return h[i] < h[j] }
+func (h indexHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *indexHeap) Push(x interface{}) { *h = append(*h, x.(int)) }
+func (h *indexHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// Branches groups pending versioned migrations into independent branches,
+// migrations of different branches do not depend on each other and could be applied separately.
+// Migrations without dependencies form branches of their own.
+// Branches are in order of their first migration, migrations in execution order.
+// Repeatable migrations are not included, they always run after all branches.
+func (u *Upgrader) Branches() ([][]Migration, error) {
+	pending, _, err := u.pending()
+	if err != nil {
+		return nil, err
+	}
+
+	var versioned []Migration
+	for _, mig := range pending {
+		if !mig.Repeatable {
+			versioned = append(versioned, mig)
+		}
+	}
+
+	// union-find over dependencies between pending migrations
+	parent := make([]int, len(versioned))
+	index := make(map[string]int, len(versioned))
+	for i, mig := range versioned {
+		parent[i] = i
+		index[mig.ID] = i
+	}
+	var find func(i int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	for i, mig := range versioned {
+		for _, dep := range mig.DependsOn {
+			if j, ok := index[dep]; ok {
+				ri, rj := find(i), find(j)
+				// root is always the earliest migration of branch
+				if ri < rj {
+					parent[rj] = ri
+				} else {
+					parent[ri] = rj
+				}
+			}
+		}
+	}
+
+	var branches [][]Migration
+	branchOf := map[int]int{}
+	for i, mig := range versioned {
+		root := find(i)
+		b, ok := branchOf[root]
+		if !ok {
+			b = len(branches)
+			branchOf[root] = b
+			branches = append(branches, nil)
+		}
+		branches[b] = append(branches[b], mig)
+	}
+	return branches, nil
+}
@@ -0,0 +1,117 @@
+package migrations_test
+
+import (
+	"strings"
+	"testing"
+
+	migrations "github.com/ulexxander/go-db-migrations"
+)
+
+func migrationIDs(migs []migrations.Migration) string {
+	ids := make([]string, 0, len(migs))
+	for _, mig := range migs {
+		ids = append(ids, mig.ID)
+	}
+	return strings.Join(ids, " ")
+}
+
+func TestSortByDependencies(t *testing.T) {
+	migs := []migrations.Migration{
+		migrations.NewMigration("a.sql", "-- migrate:depends c.sql\nSELECT 1"),
+		migrations.NewMigration("b.sql", "SELECT 1"),
+		migrations.NewMigration("c.sql", "SELECT 1"),
+		migrations.NewMigration("d.sql", "-- migrate:depends a.sql, old.sql\nSELECT 1"),
+		migrations.NewMigration("e.sql", "-- migrate:squashes old.sql\nSELECT 1"),
+	}
+
+	sorted, err := migrations.SortByDependencies(migs)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if ids := migrationIDs(sorted); ids != "b.sql c.sql a.sql e.sql d.sql" {
+		t.Errorf("unexpected order: %s", ids)
+	}
+
+	linear := migs[1:3]
+	sorted, err = migrations.SortByDependencies(linear)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if ids := migrationIDs(sorted); ids != "b.sql c.sql" {
+		t.Errorf("expected source order without dependencies, got: %s", ids)
+	}
+}
+
+func TestSortByDependenciesErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		migs []migrations.Migration
+		err  string
+	}{
+		{
+			name: "missing",
+			migs: []migrations.Migration{
+				migrations.NewMigration("a.sql", "-- migrate:depends x.sql\nSELECT 1"),
+			},
+			err: "migration a.sql depends on x.sql which is not in source",
+		},
+		{
+			name: "cycle",
+			migs: []migrations.Migration{
+				migrations.NewMigration("a.sql", "SELECT 1"),
+				migrations.NewMigration("b.sql", "-- migrate:depends d.sql\nSELECT 1"),
+				migrations.NewMigration("c.sql", "-- migrate:depends b.sql\nSELECT 1"),
+				migrations.NewMigration("d.sql", "-- migrate:depends c.sql\nSELECT 1"),
+			},
+			err: "dependency cycle between migrations: b.sql -> d.sql -> c.sql -> b.sql",
+		},
+		{
+			name: "repeatable",
+			migs: []migrations.Migration{
+				migrations.NewMigration("R__views.sql", "SELECT 1"),
+				migrations.NewMigration("a.sql", "-- migrate:depends R__views.sql\nSELECT 1"),
+			},
+			err: "migration a.sql can not depend on repeatable migration R__views.sql",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := migrations.SortByDependencies(tt.migs)
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("expected error %q, got: %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestBranches(t *testing.T) {
+	db := DatabaseMock{}
+	db.RecordExecution(migrations.Migration{ID: "1_base.sql"}, 0)
+
+	src := migrations.SourceDirect{
+		migrations.NewMigration("1_base.sql", "SELECT 1"),
+		migrations.NewMigration("2_users.sql", "-- migrate:depends 1_base.sql\nSELECT 1"),
+		migrations.NewMigration("3_orders.sql", "-- migrate:depends 1_base.sql\nSELECT 1"),
+		migrations.NewMigration("4_user_roles.sql", "-- migrate:depends 2_users.sql\nSELECT 1"),
+		migrations.NewMigration("R__views.sql", "SELECT 1"),
+	}
+	u := migrations.Upgrader{
+		Source:   &src,
+		Database: &db,
+	}
+
+	branches, err := u.Branches()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(branches) != 2 {
+		t.Fatalf("expected 2 branches, got: %d", len(branches))
+	}
+	if ids := migrationIDs(branches[0]); ids != "2_users.sql 4_user_roles.sql" {
+		t.Errorf("unexpected first branch: %s", ids)
+	}
+	if ids := migrationIDs(branches[1]); ids != "3_orders.sql" {
+		t.Errorf("unexpected second branch: %s", ids)
+	}
+}
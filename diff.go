@@ -0,0 +1,55 @@
+package migrations
+
+import "fmt"
+
+// SourceDiff lists changes of migrations between two sources.
+type SourceDiff struct {
+	// Added are migrations missing in first source, in order of second one.
+	Added []Migration
+	// Removed are migrations missing in second source, in order of first one.
+	Removed []Migration
+	// Modified are migrations of second source with checksum different from first one.
+	Modified []Migration
+}
+
+// Empty reports whether sources have the same migrations.
+func (d *SourceDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Modified) == 0
+}
+
+// DiffSources compares migrations of two sources by ID and checksum,
+// for example of two git revisions, see SourceGit.
+func DiffSources(from, to Source) (*SourceDiff, error) {
+	fromMigs, err := from.Migrations()
+	if err != nil {
+		return nil, fmt.Errorf("could not read first source: %w", err)
+	}
+	toMigs, err := to.Migrations()
+	if err != nil {
+		return nil, fmt.Errorf("could not read second source: %w", err)
+	}
+
+	fromByID := make(map[string]Migration, len(fromMigs))
+	for _, mig := range fromMigs {
+		fromByID[mig.ID] = mig
+	}
+	toIDs := make(map[string]bool, len(toMigs))
+
+	var diff SourceDiff
+	for _, mig := range toMigs {
+		toIDs[mig.ID] = true
+		prev, ok := fromByID[mig.ID]
+		switch {
+		case !ok:
+			diff.Added = append(diff.Added, mig)
+		case prev.Checksum != mig.Checksum:
+			diff.Modified = append(diff.Modified, mig)
+		}
+	}
+	for _, mig := range fromMigs {
+		if !toIDs[mig.ID] {
+			diff.Removed = append(diff.Removed, mig)
+		}
+	}
+	return &diff, nil
+}
@@ -0,0 +1,42 @@
+package migrations_test
+
+import (
+	"testing"
+
+	migrations "github.com/ulexxander/go-db-migrations"
+)
+
+func TestDiffSources(t *testing.T) {
+	from := migrations.SourceDirect{
+		migrations.NewMigration("1_users.sql", "CREATE TABLE users (id int)"),
+		migrations.NewMigration("2_orders.sql", "CREATE TABLE orders (id int)"),
+		migrations.NewMigration("3_legacy.sql", "CREATE TABLE legacy (id int)"),
+	}
+	to := migrations.SourceDirect{
+		migrations.NewMigration("1_users.sql", "CREATE TABLE users (id int)"),
+		migrations.NewMigration("2_orders.sql", "CREATE TABLE orders (id bigint)"),
+		migrations.NewMigration("4_items.sql", "CREATE TABLE items (id int)"),
+	}
+
+	diff, err := migrations.DiffSources(from, to)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if ids := migrationIDs(diff.Added); ids != "4_items.sql" {
+		t.Errorf("unexpected added: %s", ids)
+	}
+	if ids := migrationIDs(diff.Removed); ids != "3_legacy.sql" {
+		t.Errorf("unexpected removed: %s", ids)
+	}
+	if ids := migrationIDs(diff.Modified); ids != "2_orders.sql" {
+		t.Errorf("unexpected modified: %s", ids)
+	}
+
+	diff, err = migrations.DiffSources(from, from)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !diff.Empty() {
+		t.Errorf("expected empty diff, got: %+v", diff)
+	}
+}
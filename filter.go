@@ -0,0 +1,86 @@
+package migrations
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+)
+
+// DefaultPatterns match names of migration files when SourceFS.Patterns are empty.
+var DefaultPatterns = []string{"*.sql"}
+
+// ignoreFile in migrations root lists patterns of files and directories to skip,
+// one per line, blank lines and lines starting with "#" are ignored.
+// Pattern with slash is matched against path relative to migrations root,
+// otherwise against name of each file and directory. Trailing slash matches directories only.
+const ignoreFile = ".migrationsignore"
+
+type ignoreRules []string
+
+// readIgnoreFile reads rules of ignore file in root, if it exists.
+func readIgnoreFile(fsys fs.FS, root string) (ignoreRules, error) {
+	f, err := fsys.Open(path.Join(root, ignoreFile))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("could not open %s: %w", ignoreFile, err)
+	}
+	defer f.Close()
+
+	var rules ignoreRules
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if _, err := path.Match(line, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q in %s: %w", line, ignoreFile, err)
+		}
+		rules = append(rules, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("could not read %s: %w", ignoreFile, err)
+	}
+	return rules, nil
+}
+
+// ignored reports whether file or directory at path relative to migrations root matches any rule.
+func (rules ignoreRules) ignored(relPath string, dir bool) bool {
+	for _, rule := range rules {
+		dirOnly := strings.HasSuffix(rule, "/")
+		if dirOnly && !dir {
+			continue
+		}
+		rule = strings.TrimSuffix(rule, "/")
+
+		if strings.Contains(rule, "/") {
+			if ok, _ := path.Match(strings.TrimPrefix(rule, "/"), relPath); ok {
+				return true
+			}
+			continue
+		}
+		if ok, _ := path.Match(rule, path.Base(relPath)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// isHidden reports whether file name starts with dot, like .DS_Store or editor swap files.
+func isHidden(name string) bool {
+	return strings.HasPrefix(name, ".") && name != "." && name != ".."
+}
+
+func matchesAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
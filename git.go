@@ -0,0 +1,130 @@
+package migrations
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// SourceGit reads migrations of directory as they are at revision of its git repository,
+// without checking it out. It runs git command, which has to be installed.
+type SourceGit struct {
+	// Dir is migrations directory in working tree of repository.
+	Dir string
+	// Rev is git revision, like commit hash, branch, tag or HEAD~5.
+	Rev string
+	// Options of reading migrations, see SourceFS. Its FS and Dir are set by SourceGit.
+	Options SourceFS
+}
+
+func (sg *SourceGit) Migrations() ([]Migration, error) {
+	if sg.Rev == "" || strings.HasPrefix(sg.Rev, "-") {
+		return nil, fmt.Errorf("invalid git revision: %q", sg.Rev)
+	}
+
+	out, err := git(sg.Dir, "rev-parse", "--show-toplevel", "--show-prefix")
+	if err != nil {
+		return nil, fmt.Errorf("could not find git repository of %s: %w", sg.Dir, err)
+	}
+	lines := strings.Split(strings.TrimRight(string(out), "\n"), "\n")
+	top, prefix := lines[0], ""
+	if len(lines) > 1 {
+		prefix = strings.TrimSuffix(lines[1], "/")
+	}
+
+	// files are read as blobs, git archive would apply export-ignore and export-subst attributes
+	files, err := gitFiles(top, sg.Rev, prefix)
+	if err != nil {
+		return nil, fmt.Errorf("could not read %s at %s: %w", sg.Dir, sg.Rev, err)
+	}
+
+	sf := sg.Options
+	sf.FS = files
+	sf.Dir = "."
+	migs, err := sf.Migrations()
+	if err != nil {
+		return nil, fmt.Errorf("could not read %s at %s: %w", sg.Dir, sg.Rev, err)
+	}
+	return migs, nil
+}
+
+// gitFiles reads files of directory prefix of repository top at rev,
+// keyed by paths relative to prefix.
+func gitFiles(top, rev, prefix string) (memFS, error) {
+	args := []string{"ls-tree", "-r", "-z", rev}
+	if prefix != "" {
+		args = append(args, "--", prefix+"/")
+	}
+	out, err := git(top, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	// entries are "<mode> <type> <object>\t<path>", symlinks and submodules are skipped
+	var paths []string
+	var objects bytes.Buffer
+	for _, entry := range strings.Split(string(out), "\x00") {
+		info, p, ok := cut(entry, "\t")
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(info)
+		if len(fields) != 3 || fields[1] != "blob" || fields[0] == "120000" {
+			continue
+		}
+		if prefix != "" {
+			p = strings.TrimPrefix(p, prefix+"/")
+		}
+		paths = append(paths, p)
+		objects.WriteString(fields[2] + "\n")
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("directory %s does not exist", prefix)
+	}
+
+	// output of batch is "<object> <type> <size>\n<content>\n" for each object
+	out, err = gitInput(top, &objects, "cat-file", "--batch")
+	if err != nil {
+		return nil, err
+	}
+	files := memFS{}
+	batch := string(out)
+	for _, p := range paths {
+		header, rest, ok := cut(batch, "\n")
+		fields := strings.Fields(header)
+		if !ok || len(fields) != 3 {
+			return nil, fmt.Errorf("unexpected output of git cat-file: %q", header)
+		}
+		size, err := strconv.Atoi(fields[2])
+		if err != nil || size+1 > len(rest) {
+			return nil, fmt.Errorf("unexpected output of git cat-file: %q", header)
+		}
+		files[p] = []byte(rest[:size])
+		batch = rest[size+1:]
+	}
+	return files, nil
+}
+
+// git runs git command in dir, returning its output or error with its stderr.
+func git(dir string, args ...string) ([]byte, error) {
+	return gitInput(dir, nil, args...)
+}
+
+// gitInput runs git command like git, with stdin read from input.
+func gitInput(dir string, input io.Reader, args ...string) ([]byte, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+	cmd.Stdin = input
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%s: %s", err, msg)
+		}
+		return nil, err
+	}
+	return out, nil
+}
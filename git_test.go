@@ -0,0 +1,119 @@
+package migrations_test
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	migrations "github.com/ulexxander/go-db-migrations"
+)
+
+func gitRun(t *testing.T, dir string, args ...string) {
+	t.Helper()
+	args = append([]string{"-C", dir, "-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)
+	if out, err := exec.Command("git", args...).CombinedOutput(); err != nil {
+		t.Fatalf("git %v failed: %s: %s", args, err, out)
+	}
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatalf("could not create directory: %s", err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("could not write %s: %s", path, err)
+	}
+}
+
+func TestSourceGit(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	repo := t.TempDir()
+	dir := filepath.Join(repo, "db", "migrations")
+	gitRun(t, repo, "init", "-q")
+
+	writeFile(t, filepath.Join(dir, "001_users.sql"), "CREATE TABLE users (id int);")
+	writeFile(t, filepath.Join(dir, "002_orders.sql"), "CREATE TABLE orders (id int);")
+	gitRun(t, repo, "add", ".")
+	gitRun(t, repo, "commit", "-q", "-m", "first")
+
+	writeFile(t, filepath.Join(dir, "002_orders.sql"), "CREATE TABLE orders (id bigint);")
+	writeFile(t, filepath.Join(dir, "nested", "003_items.sql"), "CREATE TABLE items (id int);")
+	gitRun(t, repo, "add", ".")
+	gitRun(t, repo, "commit", "-q", "-m", "second")
+
+	// uncommitted changes are not read
+	writeFile(t, filepath.Join(dir, "004_draft.sql"), "SELECT 1;")
+
+	first := migrations.SourceGit{Dir: dir, Rev: "HEAD~1"}
+	migs, err := first.Migrations()
+	if err != nil {
+		t.Fatalf("could not read migrations: %s", err)
+	}
+	if ids := migrationIDs(migs); ids != "001_users.sql 002_orders.sql" {
+		t.Errorf("unexpected migrations at HEAD~1: %s", ids)
+	}
+
+	head := migrations.SourceGit{Dir: dir, Rev: "HEAD"}
+	migs, err = head.Migrations()
+	if err != nil {
+		t.Fatalf("could not read migrations: %s", err)
+	}
+	if ids := migrationIDs(migs); ids != "001_users.sql 002_orders.sql 003_items.sql" {
+		t.Errorf("unexpected migrations at HEAD: %s", ids)
+	}
+
+	diff, err := migrations.DiffSources(&first, &head)
+	if err != nil {
+		t.Fatalf("could not diff revisions: %s", err)
+	}
+	if migrationIDs(diff.Added) != "003_items.sql" || migrationIDs(diff.Modified) != "002_orders.sql" || len(diff.Removed) != 0 {
+		t.Errorf("unexpected diff: %+v", diff)
+	}
+
+	for _, rev := range []string{"", "--output=x", "unknown"} {
+		src := migrations.SourceGit{Dir: dir, Rev: rev}
+		if _, err := src.Migrations(); err == nil {
+			t.Errorf("expected error of revision %q", rev)
+		}
+	}
+}
+
+func TestSourceGitExportAttributes(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	repo := t.TempDir()
+	dir := filepath.Join(repo, "migrations")
+	gitRun(t, repo, "init", "-q")
+
+	// attributes of git archive do not change migrations
+	writeFile(t, filepath.Join(repo, ".gitattributes"), "001_users.sql export-ignore\n002_version.sql export-subst\n")
+	writeFile(t, filepath.Join(dir, "001_users.sql"), "CREATE TABLE users (id int);")
+	writeFile(t, filepath.Join(dir, "002_version.sql"), "SELECT '$Format:%H$';")
+	gitRun(t, repo, "add", ".")
+	gitRun(t, repo, "commit", "-q", "-m", "first")
+
+	src := migrations.SourceGit{Dir: dir, Rev: "HEAD"}
+	migs, err := src.Migrations()
+	if err != nil {
+		t.Fatalf("could not read migrations: %s", err)
+	}
+	if ids := migrationIDs(migs); ids != "001_users.sql 002_version.sql" {
+		t.Fatalf("unexpected migrations: %s", ids)
+	}
+	if migs[1].Content != "SELECT '$Format:%H$';" {
+		t.Errorf("unexpected content: %q", migs[1].Content)
+	}
+
+	missing := migrations.SourceGit{Dir: filepath.Join(repo, "other"), Rev: "HEAD"}
+	os.MkdirAll(missing.Dir, 0755)
+	if _, err := missing.Migrations(); err == nil {
+		t.Errorf("expected error of directory missing at revision")
+	}
+}
@@ -5,6 +5,7 @@ go 1.17
 require (
 	github.com/lib/pq v1.10.3
 	github.com/sirupsen/logrus v1.8.1
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require golang.org/x/sys v0.0.0-20191026070338-33540a1f6037 // indirect
@@ -0,0 +1,97 @@
+package migrations
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// StatusResponse is returned by StatusHandler.
+type StatusResponse struct {
+	// Ready is false when there are pending or drifted migrations.
+	Ready bool `json:"ready"`
+	// Pending is count of migrations to be executed.
+	Pending int `json:"pending"`
+	// Drifted is count of modified and missing migrations.
+	Drifted    int               `json:"drifted"`
+	Migrations []MigrationStatus `json:"migrations,omitempty"`
+	Error      string            `json:"error,omitempty"`
+}
+
+// StatusHandler reports migrations status as JSON, for readiness probes.
+// It responds with 503 Service Unavailable when database is not ready:
+// there are pending or drifted migrations, or status can not be loaded.
+// Source migrations are read once, as they do not change while application runs,
+// each request only reads migrations tables.
+type StatusHandler struct {
+	Upgrader *Upgrader
+	// MaxAge of status, which is loaded at most once per MaxAge.
+	// Zero loads it on each request.
+	MaxAge time.Duration
+
+	mu       sync.Mutex
+	source   SourceDirect
+	resp     StatusResponse
+	loadedAt time.Time
+}
+
+func (h *StatusHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	resp := h.status()
+
+	code := http.StatusOK
+	if !resp.Ready {
+		code = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// status returns status loaded within MaxAge or loads it.
+func (h *StatusHandler) status() StatusResponse {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.MaxAge > 0 && !h.loadedAt.IsZero() && time.Since(h.loadedAt) < h.MaxAge {
+		return h.resp
+	}
+
+	var resp StatusResponse
+	statuses, err := h.statuses()
+	if err != nil {
+		h.Upgrader.logger().Error("Could not get migrations status", "error", err)
+		resp.Error = err.Error()
+	} else {
+		resp.Migrations = statuses
+		resp.Pending = len(FilterStatuses(statuses, StatePending))
+		resp.Drifted = len(FilterStatuses(statuses, StateModified, StateMissing))
+		resp.Ready = resp.Pending == 0 && resp.Drifted == 0
+	}
+	h.resp = resp
+	h.loadedAt = time.Now()
+	return resp
+}
+
+// statuses returns status of source migrations read once, with their checksums.
+func (h *StatusHandler) statuses() ([]MigrationStatus, error) {
+	if h.source == nil {
+		migs, err := h.Upgrader.Source.Migrations()
+		if err != nil {
+			return nil, fmt.Errorf("could not read source migrations: %w", err)
+		}
+		source := make(SourceDirect, 0, len(migs))
+		for _, mig := range migs {
+			if mig.Checksum == "" {
+				mig.Checksum = Checksum(mig.Content)
+			}
+			source = append(source, mig)
+		}
+		h.source = source
+	}
+
+	u := *h.Upgrader
+	u.Source = h.source
+	return u.Status()
+}
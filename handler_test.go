@@ -0,0 +1,113 @@
+package migrations_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	migrations "github.com/ulexxander/go-db-migrations"
+)
+
+func getStatus(t *testing.T, h http.Handler) (int, migrations.StatusResponse) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/status", nil))
+
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("expected json content type, got: %s", ct)
+	}
+
+	var resp migrations.StatusResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("could not decode response: %s", err)
+	}
+	return rec.Code, resp
+}
+
+func TestStatusHandler(t *testing.T) {
+	db := DatabaseMock{}
+
+	src := migrations.SourceDirect{{ID: "1.sql"}, {ID: "2.sql"}}
+	u := migrations.Upgrader{
+		Source:   &src,
+		Database: &db,
+	}
+	h := migrations.StatusHandler{Upgrader: &u}
+
+	code, resp := getStatus(t, &h)
+	if code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 when migrations are pending, got: %d", code)
+	}
+	if resp.Ready || resp.Pending != 2 {
+		t.Errorf("expected 2 pending migrations and not ready, got: %+v", resp)
+	}
+
+	if _, err := u.Do(); err != nil {
+		t.Fatalf("unexpected upgrader error: %s", err)
+	}
+
+	code, resp = getStatus(t, &h)
+	if code != http.StatusOK {
+		t.Errorf("expected 200 when migrations are applied, got: %d", code)
+	}
+	if !resp.Ready || len(resp.Migrations) != 2 {
+		t.Errorf("expected ready with 2 migrations, got: %+v", resp)
+	}
+
+	// source is read once by handler
+	src[1].Content = "changed"
+	if code, _ := getStatus(t, &h); code != http.StatusOK {
+		t.Errorf("expected source not to be read again, got: %d", code)
+	}
+
+	h = migrations.StatusHandler{Upgrader: &u}
+	code, resp = getStatus(t, &h)
+	if code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 when migration is modified, got: %d", code)
+	}
+	if resp.Drifted != 1 {
+		t.Errorf("expected 1 drifted migration, got: %d", resp.Drifted)
+	}
+}
+
+func TestStatusHandlerMaxAge(t *testing.T) {
+	db := DatabaseMock{}
+
+	src := migrations.SourceDirect{{ID: "1.sql"}}
+	u := migrations.Upgrader{
+		Source:   &src,
+		Database: &db,
+	}
+	h := migrations.StatusHandler{Upgrader: &u, MaxAge: time.Hour}
+
+	if code, _ := getStatus(t, &h); code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 when migrations are pending, got: %d", code)
+	}
+	if _, err := u.Do(); err != nil {
+		t.Fatalf("unexpected upgrader error: %s", err)
+	}
+	if code, _ := getStatus(t, &h); code != http.StatusServiceUnavailable {
+		t.Errorf("expected status to be kept for max age, got: %d", code)
+	}
+}
+
+func TestStatusHandlerError(t *testing.T) {
+	db := DatabaseMock{executedErrs: []error{errors.New(someDBError)}}
+
+	src := migrations.SourceDirect{{ID: "1.sql"}}
+	h := migrations.StatusHandler{Upgrader: &migrations.Upgrader{
+		Source:   &src,
+		Database: &db,
+	}}
+
+	code, resp := getStatus(t, &h)
+	if code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 on database error, got: %d", code)
+	}
+	if resp.Error == "" {
+		t.Errorf("expected error in response")
+	}
+}
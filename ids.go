@@ -0,0 +1,97 @@
+package migrations
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// IDScheme defines how SourceFS derives migration IDs from file paths.
+type IDScheme int
+
+const (
+	// IDBaseName uses file name, like "001_init.sql" for "pre/001_init.sql".
+	IDBaseName IDScheme = iota
+	// IDRelPath uses slash separated path relative to migrations root,
+	// like "pre/001_init.sql", so that files in different directories may have the same name.
+	IDRelPath
+)
+
+// migrationID returns ID of migration file at path relative to migrations root.
+func migrationID(relPath string, scheme IDScheme, stripExtension bool) string {
+	id := relPath
+	if scheme == IDBaseName {
+		id = path.Base(relPath)
+	}
+	if stripExtension {
+		id = strings.TrimSuffix(id, path.Ext(id))
+	}
+	return id
+}
+
+// checkIDCollisions returns error when different files get the same ID.
+func checkIDCollisions(relPaths []string, scheme IDScheme, stripExtension bool) error {
+	pathByID := map[string]string{}
+	for _, p := range relPaths {
+		id := migrationID(p, scheme, stripExtension)
+		if other, ok := pathByID[id]; ok {
+			return fmt.Errorf("migrations %s and %s have the same id %s", other, p, id)
+		}
+		pathByID[id] = p
+	}
+	return nil
+}
+
+// IDRenames returns current IDs of migrations by their IDs in previous scheme,
+// for migrations whose ID differs, see DatabasePostgres.RenameMigrations.
+// Of files having the same previous ID only the first one in walk order is renamed.
+func (sf *SourceFS) IDRenames(prevScheme IDScheme, prevStripExtension bool) (map[string]string, error) {
+	paths, err := sf.paths()
+	if err != nil {
+		return nil, fmt.Errorf("could get migration files paths: %w", err)
+	}
+
+	relPaths := make([]string, 0, len(paths))
+	for _, p := range paths {
+		relPaths = append(relPaths, sf.relPath(p))
+	}
+	if err := checkIDCollisions(relPaths, sf.IDScheme, sf.StripExtension); err != nil {
+		return nil, err
+	}
+
+	// when files had the same previous ID, executed one was the first in walk order,
+	// others are not renamed and become pending
+	renames := map[string]string{}
+	taken := map[string]bool{}
+	for _, p := range relPaths {
+		prev := migrationID(p, prevScheme, prevStripExtension)
+		if taken[prev] {
+			continue
+		}
+		taken[prev] = true
+		if id := migrationID(p, sf.IDScheme, sf.StripExtension); id != prev {
+			renames[prev] = id
+		}
+	}
+	return renames, nil
+}
+
+// IDRenames is SourceFS.IDRenames of directory.
+func (sr *SourceDir) IDRenames(prevScheme IDScheme, prevStripExtension bool) (map[string]string, error) {
+	renames, err := sr.sourceFS().IDRenames(prevScheme, prevStripExtension)
+	if err != nil {
+		return nil, fmt.Errorf("could not read directory %s: %w", sr.Dir, err)
+	}
+	return renames, nil
+}
+
+// sortedRenames returns old IDs of renames in order.
+func sortedRenames(renames map[string]string) []string {
+	ids := make([]string, 0, len(renames))
+	for id := range renames {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
@@ -0,0 +1,84 @@
+package migrations_test
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	migrations "github.com/ulexxander/go-db-migrations"
+)
+
+var nestedFS = fstest.MapFS{
+	"billing/001_init.sql":     {Data: []byte("SELECT 1")},
+	"users/001_init.sql":       {Data: []byte("SELECT 1")},
+	"users/R__views.sql":       {Data: []byte("SELECT 1")},
+	"users/002_add_email.sql":  {Data: []byte("SELECT 1")},
+	"billing/002_invoices.sql": {Data: []byte("SELECT 1")},
+}
+
+func TestSourceFSIDScheme(t *testing.T) {
+	src := migrations.SourceFS{FS: nestedFS, IDScheme: migrations.IDRelPath, StripExtension: true}
+
+	migs, err := src.Migrations()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := "billing/001_init billing/002_invoices users/001_init users/002_add_email users/R__views"
+	if ids := migrationIDs(migs); ids != expected {
+		t.Errorf("unexpected ids: %s", ids)
+	}
+	if !migs[4].Repeatable {
+		t.Errorf("expected %s to be repeatable", migs[4].ID)
+	}
+}
+
+func TestSourceFSIDCollision(t *testing.T) {
+	src := migrations.SourceFS{FS: nestedFS}
+
+	_, err := src.Migrations()
+	if err == nil || !strings.Contains(err.Error(), "migrations billing/001_init.sql and users/001_init.sql have the same id 001_init.sql") {
+		t.Errorf("expected collision error, got: %v", err)
+	}
+}
+
+func TestSourceFSIDRenames(t *testing.T) {
+	fsys := fstest.MapFS{
+		"001_init.sql":      {Data: []byte("SELECT 1")},
+		"post/002_drop.sql": {Data: []byte("SELECT 1")},
+	}
+	src := migrations.SourceFS{FS: fsys, IDScheme: migrations.IDRelPath, StripExtension: true}
+
+	renames, err := src.IDRenames(migrations.IDBaseName, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(renames) != 2 || renames["001_init.sql"] != "001_init" || renames["002_drop.sql"] != "post/002_drop" {
+		t.Errorf("unexpected renames: %v", renames)
+	}
+
+	src.StripExtension = false
+	renames, err = src.IDRenames(migrations.IDBaseName, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(renames) != 1 || renames["002_drop.sql"] != "post/002_drop.sql" {
+		t.Errorf("expected only nested migration to be renamed, got: %v", renames)
+	}
+}
+
+func TestSourceFSIDRenamesPreviousCollision(t *testing.T) {
+	fsys := fstest.MapFS{
+		"a/001.sql": {Data: []byte("SELECT 1")},
+		"b/001.sql": {Data: []byte("SELECT 2")},
+		"b/002.sql": {Data: []byte("SELECT 3")},
+	}
+	src := migrations.SourceFS{FS: fsys, IDScheme: migrations.IDRelPath}
+
+	renames, err := src.IDRenames(migrations.IDBaseName, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(renames) != 2 || renames["001.sql"] != "a/001.sql" || renames["002.sql"] != "b/002.sql" {
+		t.Errorf("expected first of colliding files to be renamed, got: %v", renames)
+	}
+}
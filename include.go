@@ -0,0 +1,76 @@
+package migrations
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+)
+
+// includeDirective line is replaced by content of file it references,
+// path is relative to migrations root, like "-- migrate:include _shared/audit_trigger.sql".
+const includeDirective = directivePrefix + "include"
+
+// fragmentPrefix marks files and directories with fragments included by migrations,
+// they are not migrations themselves.
+const fragmentPrefix = "_"
+
+// expandIncludes replaces include directives of content read from file at p
+// with content of included files, recursively.
+// Stack holds paths of files being expanded, to detect include cycles.
+func (sf *SourceFS) expandIncludes(p, content string, stack []string) (string, error) {
+	stack = append(stack, p)
+
+	lines := strings.Split(content, "\n")
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if !strings.HasPrefix(trimmed, includeDirective+" ") {
+			continue
+		}
+
+		included, err := sf.includePath(strings.TrimSpace(strings.TrimPrefix(trimmed, includeDirective)))
+		if err != nil {
+			return "", fmt.Errorf("file %s: %w", p, err)
+		}
+		for _, s := range stack {
+			if s == included {
+				return "", fmt.Errorf("include cycle: %s -> %s", strings.Join(stack, " -> "), included)
+			}
+		}
+
+		fragment, err := fs.ReadFile(sf.FS, included)
+		if err != nil {
+			return "", fmt.Errorf("file %s: could not include %s: %w", p, included, err)
+		}
+		expanded, err := sf.expandIncludes(included, string(fragment), stack)
+		if err != nil {
+			return "", err
+		}
+		lines[i] = strings.TrimSuffix(expanded, "\n")
+	}
+
+	return strings.Join(lines, "\n"), nil
+}
+
+// includePath resolves path of included file relative to migrations root.
+func (sf *SourceFS) includePath(p string) (string, error) {
+	if p == "" {
+		return "", fmt.Errorf("include path is empty")
+	}
+	cleaned := path.Clean(p)
+	if path.IsAbs(cleaned) || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", fmt.Errorf("include path %s is outside of migrations directory", p)
+	}
+	return path.Join(sf.root(), cleaned), nil
+}
+
+// isFragment reports whether file at path relative to migrations root
+// is fragment for includes or is in directory of them.
+func isFragment(relPath string) bool {
+	for _, part := range strings.Split(relPath, "/") {
+		if strings.HasPrefix(part, fragmentPrefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,110 @@
+package migrations_test
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	migrations "github.com/ulexxander/go-db-migrations"
+)
+
+func TestSourceFSIncludes(t *testing.T) {
+	fsys := fstest.MapFS{
+		"migrations/1_users.sql":        {Data: []byte("CREATE TABLE users (id int);\n-- migrate:include _shared/audit.sql\n")},
+		"migrations/2_orders.sql":       {Data: []byte("CREATE TABLE orders (id int);\n  -- migrate:include _shared/audit.sql\n")},
+		"migrations/_shared/audit.sql":  {Data: []byte("CREATE TRIGGER audit;\n-- migrate:include _shared/grants.sql\n")},
+		"migrations/_shared/grants.sql": {Data: []byte("GRANT SELECT;\n")},
+	}
+	src := migrations.SourceFS{FS: fsys, Dir: "migrations"}
+
+	migs, err := src.Migrations()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(migs) != 2 {
+		t.Fatalf("expected fragments not to be migrations, got: %s", migrationIDs(migs))
+	}
+	expected := "CREATE TABLE users (id int);\nCREATE TRIGGER audit;\nGRANT SELECT;\n"
+	if migs[0].Content != expected {
+		t.Errorf("unexpected expanded content: %q", migs[0].Content)
+	}
+	if migs[0].Checksum != migrations.Checksum(expected) {
+		t.Errorf("expected checksum of expanded content")
+	}
+	checksum := migs[1].Checksum
+
+	fsys["migrations/_shared/grants.sql"] = &fstest.MapFile{Data: []byte("GRANT SELECT, INSERT;\n")}
+	migs, err = src.Migrations()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if migs[1].Checksum == checksum {
+		t.Errorf("expected checksum to change with included fragment")
+	}
+}
+
+func TestSourceFSIncludeInHeader(t *testing.T) {
+	fsys := fstest.MapFS{
+		"1_base.sql":  {Data: []byte("SELECT 1;\n")},
+		"2_views.sql": {Data: []byte("-- migrate:include _header.sql\n-- migrate:depends 1_base.sql\n-- migrate:phase post\nCREATE VIEW v AS SELECT 1;\n")},
+		"_header.sql": {Data: []byte("-- migrate:tags fragment\nSET lock_timeout = '5s';\n")},
+	}
+	migs, err := (&migrations.SourceFS{FS: fsys}).Migrations()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	mig := migs[1]
+	if len(mig.DependsOn) != 1 || mig.DependsOn[0] != "1_base.sql" || mig.Phase != migrations.PhasePost {
+		t.Errorf("expected directives after include to be parsed, got: %+v", mig)
+	}
+	if len(mig.Tags) != 0 {
+		t.Errorf("expected directives of fragment not to apply, got tags: %v", mig.Tags)
+	}
+	if !strings.Contains(mig.Content, "SET lock_timeout") || mig.Checksum != migrations.Checksum(mig.Content) {
+		t.Errorf("expected expanded content and its checksum, got: %q", mig.Content)
+	}
+}
+
+func TestSourceFSIncludeErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		fsys fstest.MapFS
+		err  string
+	}{
+		{
+			name: "cycle",
+			fsys: fstest.MapFS{
+				"1.sql":    {Data: []byte("-- migrate:include _a.sql")},
+				"_a.sql":   {Data: []byte("-- migrate:include _b.sql")},
+				"_b.sql":   {Data: []byte("-- migrate:include _a.sql")},
+				"_c.sql":   {Data: []byte("SELECT 1")},
+				"_d/x.sql": {Data: []byte("SELECT 1")},
+			},
+			err: "include cycle: 1.sql -> _a.sql -> _b.sql -> _a.sql",
+		},
+		{
+			name: "missing",
+			fsys: fstest.MapFS{
+				"1.sql": {Data: []byte("-- migrate:include _missing.sql")},
+			},
+			err: "could not include _missing.sql",
+		},
+		{
+			name: "outside",
+			fsys: fstest.MapFS{
+				"1.sql": {Data: []byte("-- migrate:include ../secret.sql")},
+			},
+			err: "outside of migrations directory",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := migrations.SourceFS{FS: tt.fsys}
+			_, err := src.Migrations()
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("expected error containing %q, got: %v", tt.err, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,151 @@
+package migrations
+
+import (
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Logger is a leveled structured logger.
+// Fields are passed as alternating key/value pairs, same as in log/slog,
+// so *slog.Logger can be used as Logger directly.
+type Logger interface {
+	Debug(msg string, keyvals ...interface{})
+	Info(msg string, keyvals ...interface{})
+	Warn(msg string, keyvals ...interface{})
+	Error(msg string, keyvals ...interface{})
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Debug(msg string, keyvals ...interface{}) {}
+func (nopLogger) Info(msg string, keyvals ...interface{})  {}
+func (nopLogger) Warn(msg string, keyvals ...interface{})  {}
+func (nopLogger) Error(msg string, keyvals ...interface{}) {}
+
+// LoggerLogrus adapts logrus logger or entry to Logger.
+type LoggerLogrus struct {
+	Logger logrus.FieldLogger
+}
+
+func (ll *LoggerLogrus) Debug(msg string, keyvals ...interface{}) {
+	ll.Logger.WithFields(logrusFields(keyvals)).Debug(msg)
+}
+
+func (ll *LoggerLogrus) Info(msg string, keyvals ...interface{}) {
+	ll.Logger.WithFields(logrusFields(keyvals)).Info(msg)
+}
+
+func (ll *LoggerLogrus) Warn(msg string, keyvals ...interface{}) {
+	ll.Logger.WithFields(logrusFields(keyvals)).Warn(msg)
+}
+
+func (ll *LoggerLogrus) Error(msg string, keyvals ...interface{}) {
+	ll.Logger.WithFields(logrusFields(keyvals)).Error(msg)
+}
+
+func logrusFields(keyvals []interface{}) logrus.Fields {
+	fields := make(logrus.Fields, len(keyvals)/2)
+	for i := 0; i < len(keyvals); i += 2 {
+		if i+1 == len(keyvals) {
+			// key without value, keep it visible instead of dropping
+			fields["!BADKEY"] = keyvals[i]
+			break
+		}
+		fields[fmt.Sprint(keyvals[i])] = keyvals[i+1]
+	}
+	return fields
+}
+
+// ZapSugaredLogger is implemented by *zap.SugaredLogger.
+// Declared here so that using LoggerZap does not require zap dependency.
+type ZapSugaredLogger interface {
+	Debugw(msg string, keysAndValues ...interface{})
+	Infow(msg string, keysAndValues ...interface{})
+	Warnw(msg string, keysAndValues ...interface{})
+	Errorw(msg string, keysAndValues ...interface{})
+}
+
+// LoggerZap adapts zap sugared logger to Logger.
+type LoggerZap struct {
+	Logger ZapSugaredLogger
+}
+
+func (lz *LoggerZap) Debug(msg string, keyvals ...interface{}) {
+	lz.Logger.Debugw(msg, keyvals...)
+}
+
+func (lz *LoggerZap) Info(msg string, keyvals ...interface{}) {
+	lz.Logger.Infow(msg, keyvals...)
+}
+
+func (lz *LoggerZap) Warn(msg string, keyvals ...interface{}) {
+	lz.Logger.Warnw(msg, keyvals...)
+}
+
+func (lz *LoggerZap) Error(msg string, keyvals ...interface{}) {
+	lz.Logger.Errorw(msg, keyvals...)
+}
+
+// PrintlnLogger is logger interface of previous versions, implemented by *log.Logger and logrus.
+//
+// Deprecated: use Logger, PrintlnLogger can be adapted to it with LoggerPrintln
+// until it is removed in next release.
+type PrintlnLogger interface {
+	Println(v ...interface{})
+}
+
+// LoggerPrintln adapts PrintlnLogger to Logger, printing message followed by keyvals.
+//
+// Deprecated: use Logger implementation, like *slog.Logger or LoggerLogrus,
+// LoggerPrintln will be removed in next release.
+type LoggerPrintln struct {
+	Logger PrintlnLogger
+}
+
+func (lp *LoggerPrintln) println(msg string, keyvals []interface{}) {
+	lp.Logger.Println(append([]interface{}{msg}, keyvals...)...)
+}
+
+func (lp *LoggerPrintln) Debug(msg string, keyvals ...interface{}) {
+	lp.println(msg, keyvals)
+}
+
+func (lp *LoggerPrintln) Info(msg string, keyvals ...interface{}) {
+	lp.println(msg, keyvals)
+}
+
+func (lp *LoggerPrintln) Warn(msg string, keyvals ...interface{}) {
+	lp.println(msg, keyvals)
+}
+
+func (lp *LoggerPrintln) Error(msg string, keyvals ...interface{}) {
+	lp.println(msg, keyvals)
+}
+
+// fieldsLogger adds keyvals to each message,
+// so that logs of concurrent upgrades can be told apart.
+type fieldsLogger struct {
+	Logger  Logger
+	keyvals []interface{}
+}
+
+func (l *fieldsLogger) with(keyvals []interface{}) []interface{} {
+	return append(append([]interface{}{}, l.keyvals...), keyvals...)
+}
+
+func (l *fieldsLogger) Debug(msg string, keyvals ...interface{}) {
+	l.Logger.Debug(msg, l.with(keyvals)...)
+}
+
+func (l *fieldsLogger) Info(msg string, keyvals ...interface{}) {
+	l.Logger.Info(msg, l.with(keyvals)...)
+}
+
+func (l *fieldsLogger) Warn(msg string, keyvals ...interface{}) {
+	l.Logger.Warn(msg, l.with(keyvals)...)
+}
+
+func (l *fieldsLogger) Error(msg string, keyvals ...interface{}) {
+	l.Logger.Error(msg, l.with(keyvals)...)
+}
@@ -0,0 +1,30 @@
+//go:build go1.21
+
+package migrations
+
+import (
+	"log/slog"
+)
+
+// LoggerSlog adapts slog logger to Logger.
+// *slog.Logger satisfies Logger by itself, LoggerSlog is here
+// to configure all loggers in the same way.
+type LoggerSlog struct {
+	Logger *slog.Logger
+}
+
+func (ls *LoggerSlog) Debug(msg string, keyvals ...interface{}) {
+	ls.Logger.Debug(msg, keyvals...)
+}
+
+func (ls *LoggerSlog) Info(msg string, keyvals ...interface{}) {
+	ls.Logger.Info(msg, keyvals...)
+}
+
+func (ls *LoggerSlog) Warn(msg string, keyvals ...interface{}) {
+	ls.Logger.Warn(msg, keyvals...)
+}
+
+func (ls *LoggerSlog) Error(msg string, keyvals ...interface{}) {
+	ls.Logger.Error(msg, keyvals...)
+}
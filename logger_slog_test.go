@@ -0,0 +1,37 @@
+//go:build go1.21
+
+package migrations_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"testing"
+
+	migrations "github.com/ulexxander/go-db-migrations"
+)
+
+func TestLoggerSlog(t *testing.T) {
+	var buf bytes.Buffer
+	l := slog.New(slog.NewJSONHandler(&buf, nil))
+
+	// *slog.Logger must satisfy Logger without adapter
+	var _ migrations.Logger = l
+
+	logger := migrations.LoggerSlog{Logger: l}
+	logger.Info("Migration executed", "id", "1.sql", "duration_ms", 5)
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("could not decode log entry: %s", err)
+	}
+	if entry["level"] != "INFO" {
+		t.Errorf("expected INFO level, got: %v", entry["level"])
+	}
+	if entry["id"] != "1.sql" {
+		t.Errorf("expected id field to be 1.sql, got: %v", entry["id"])
+	}
+	if entry["duration_ms"] != float64(5) {
+		t.Errorf("expected duration_ms field to be 5, got: %v", entry["duration_ms"])
+	}
+}
@@ -0,0 +1,157 @@
+package migrations_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	logrustest "github.com/sirupsen/logrus/hooks/test"
+	migrations "github.com/ulexxander/go-db-migrations"
+)
+
+type logEntry struct {
+	level   string
+	msg     string
+	keyvals []interface{}
+}
+
+type LoggerMock struct {
+	entries []logEntry
+}
+
+func (lm *LoggerMock) Debug(msg string, keyvals ...interface{}) {
+	lm.entries = append(lm.entries, logEntry{"debug", msg, keyvals})
+}
+
+func (lm *LoggerMock) Info(msg string, keyvals ...interface{}) {
+	lm.entries = append(lm.entries, logEntry{"info", msg, keyvals})
+}
+
+func (lm *LoggerMock) Warn(msg string, keyvals ...interface{}) {
+	lm.entries = append(lm.entries, logEntry{"warn", msg, keyvals})
+}
+
+func (lm *LoggerMock) Error(msg string, keyvals ...interface{}) {
+	lm.entries = append(lm.entries, logEntry{"error", msg, keyvals})
+}
+
+func TestUpgraderLogsWithFields(t *testing.T) {
+	db := DatabaseMock{}
+	logger := LoggerMock{}
+
+	src := migrations.SourceDirect{{ID: "1.sql"}}
+	u := migrations.Upgrader{
+		Logger:   &logger,
+		Source:   &src,
+		Database: &db,
+	}
+
+	if _, err := u.Do(); err != nil {
+		t.Fatalf("unexpected upgrader error: %s", err)
+	}
+
+	if len(logger.entries) != 2 {
+		t.Fatalf("expected 2 log entries, got: %d", len(logger.entries))
+	}
+	for _, e := range logger.entries {
+		if e.level != "info" {
+			t.Errorf("expected info level for %q, got: %s", e.msg, e.level)
+		}
+		if len(e.keyvals) < 2 || e.keyvals[0] != "id" || e.keyvals[1] != "1.sql" {
+			t.Errorf("expected %q to have id field, got: %v", e.msg, e.keyvals)
+		}
+	}
+
+	db.migrateOverride = true
+	src = append(src, migrations.Migration{ID: "2.sql"})
+	if _, err := u.Do(); err == nil {
+		t.Fatalf("expected to get error, got nil")
+	}
+
+	last := logger.entries[len(logger.entries)-1]
+	if last.level != "error" {
+		t.Fatalf("expected last entry to be error, got: %s", last.level)
+	}
+}
+
+func TestLoggerLogrus(t *testing.T) {
+	l, hook := logrustest.NewNullLogger()
+	l.SetLevel(logrus.DebugLevel)
+	logger := migrations.LoggerLogrus{Logger: l}
+
+	logger.Warn("something", "id", "1.sql", "duration_ms", 10)
+
+	e := hook.LastEntry()
+	if e == nil {
+		t.Fatalf("expected entry to be logged")
+	}
+	if e.Level != logrus.WarnLevel {
+		t.Errorf("expected warn level, got: %s", e.Level)
+	}
+	if e.Data["id"] != "1.sql" {
+		t.Errorf("expected id field to be 1.sql, got: %v", e.Data["id"])
+	}
+	if e.Data["duration_ms"] != 10 {
+		t.Errorf("expected duration_ms field to be 10, got: %v", e.Data["duration_ms"])
+	}
+
+	logger.Debug("odd", "key")
+	e = hook.LastEntry()
+	if e.Data["!BADKEY"] != "key" {
+		t.Errorf("expected dangling key to be kept, got: %v", e.Data)
+	}
+}
+
+type zapMock struct {
+	lines []string
+}
+
+func (zm *zapMock) Debugw(msg string, kv ...interface{}) { zm.log("debug", msg, kv) }
+func (zm *zapMock) Infow(msg string, kv ...interface{})  { zm.log("info", msg, kv) }
+func (zm *zapMock) Warnw(msg string, kv ...interface{})  { zm.log("warn", msg, kv) }
+func (zm *zapMock) Errorw(msg string, kv ...interface{}) { zm.log("error", msg, kv) }
+
+func (zm *zapMock) log(level, msg string, kv []interface{}) {
+	zm.lines = append(zm.lines, fmt.Sprint(level, " ", msg, " ", kv))
+}
+
+func TestLoggerZap(t *testing.T) {
+	zm := zapMock{}
+	logger := migrations.LoggerZap{Logger: &zm}
+
+	logger.Info("hello", "id", "1.sql")
+	logger.Error("bye", "id", "2.sql")
+
+	expected := []string{
+		"info hello [id 1.sql]",
+		"error bye [id 2.sql]",
+	}
+	if fmt.Sprint(zm.lines) != fmt.Sprint(expected) {
+		t.Fatalf("expected lines %v, got: %v", expected, zm.lines)
+	}
+}
+
+type printlnMock struct {
+	lines []string
+}
+
+func (pm *printlnMock) Println(v ...interface{}) {
+	pm.lines = append(pm.lines, strings.TrimSuffix(fmt.Sprintln(v...), "\n"))
+}
+
+func TestLoggerPrintln(t *testing.T) {
+	pm := printlnMock{}
+	u := migrations.Upgrader{Logger: &migrations.LoggerPrintln{Logger: &pm}}
+
+	u.Logger.Info("Executing migration", "id", "1.sql")
+	u.Println("Done,", 5)
+
+	expected := []string{
+		"Executing migration id 1.sql",
+		"Done, 5",
+	}
+	if fmt.Sprint(pm.lines) != fmt.Sprint(expected) {
+		t.Fatalf("expected lines %v, got: %v", expected, pm.lines)
+	}
+}
@@ -0,0 +1,162 @@
+package migrations
+
+import (
+	"fmt"
+	"path"
+)
+
+// MergeOrder defines how SourceMulti orders migrations of different sources.
+type MergeOrder int
+
+const (
+	// MergeByVersion interleaves migrations of sources by file names of their IDs,
+	// like timestamp versions, keeping order of migrations within each source.
+	// Equal names are taken in order of sources.
+	MergeByVersion MergeOrder = iota
+	// MergeBySource takes all migrations of each source in order of sources.
+	MergeBySource
+)
+
+// SourceModule is source of SourceMulti with name.
+type SourceModule struct {
+	Name   string
+	Source Source
+}
+
+// SourceMulti merges migrations of several sources, like migrations directories
+// owned by modules of application, so that single Upgrader run covers all of them.
+// Without Namespace IDs of migrations have to be unique across modules.
+type SourceMulti struct {
+	Modules []SourceModule
+	// Namespace prefixes IDs with module name, like "billing/001_init.sql".
+	// Dependencies and squashed IDs referring to migrations of the same module are prefixed too.
+	// Enabling it for database with executed migrations would execute all of them again,
+	// rename them first, see NamespaceRenames.
+	Namespace bool
+	// Order of merged migrations, MergeByVersion by default.
+	// Dependencies between migrations are applied afterwards, see SortByDependencies.
+	Order MergeOrder
+}
+
+func (sm *SourceMulti) Migrations() ([]Migration, error) {
+	modules := make([][]Migration, 0, len(sm.Modules))
+	names := map[string]bool{}
+	for _, m := range sm.Modules {
+		if sm.Namespace {
+			if m.Name == "" {
+				return nil, fmt.Errorf("module name can not be empty with namespace")
+			}
+			if names[m.Name] {
+				return nil, fmt.Errorf("duplicate module %s", m.Name)
+			}
+			names[m.Name] = true
+		}
+
+		migs, err := m.Source.Migrations()
+		if err != nil {
+			return nil, fmt.Errorf("could not read migrations of module %s: %w", m.Name, err)
+		}
+		if sm.Namespace {
+			migs = namespaceMigrations(m.Name, migs)
+		}
+		modules = append(modules, migs)
+	}
+
+	// module of each ID, to report duplicates
+	moduleOf := map[string]string{}
+	for i, migs := range modules {
+		for _, mig := range migs {
+			if other, ok := moduleOf[mig.ID]; ok {
+				return nil, fmt.Errorf("migration %s is in modules %s and %s", mig.ID, other, sm.Modules[i].Name)
+			}
+			moduleOf[mig.ID] = sm.Modules[i].Name
+		}
+	}
+
+	switch sm.Order {
+	case MergeByVersion:
+		return mergeByVersion(modules), nil
+	case MergeBySource:
+		var merged []Migration
+		for _, migs := range modules {
+			merged = append(merged, migs...)
+		}
+		return merged, nil
+	default:
+		return nil, fmt.Errorf("unknown merge order: %d", sm.Order)
+	}
+}
+
+// NamespaceRenames returns namespaced IDs of migrations of modules by their IDs without namespace,
+// for renaming migrations executed before Namespace was enabled, see DatabasePostgres.RenameMigrations.
+func (sm *SourceMulti) NamespaceRenames() (map[string]string, error) {
+	renames := map[string]string{}
+	for _, m := range sm.Modules {
+		if m.Name == "" {
+			return nil, fmt.Errorf("module name can not be empty with namespace")
+		}
+		migs, err := m.Source.Migrations()
+		if err != nil {
+			return nil, fmt.Errorf("could not read migrations of module %s: %w", m.Name, err)
+		}
+		for _, mig := range migs {
+			if _, ok := renames[mig.ID]; ok {
+				return nil, fmt.Errorf("migration %s is in several modules, it can not be renamed", mig.ID)
+			}
+			renames[mig.ID] = m.Name + "/" + mig.ID
+		}
+	}
+	return renames, nil
+}
+
+// namespaceMigrations prefixes IDs of migrations with name of their module.
+func namespaceMigrations(name string, migs []Migration) []Migration {
+	ids := make(map[string]bool, len(migs))
+	for _, mig := range migs {
+		ids[mig.ID] = true
+	}
+
+	namespaced := make([]Migration, 0, len(migs))
+	for _, mig := range migs {
+		mig.ID = name + "/" + mig.ID
+		// dependencies outside of module are already namespaced
+		var deps []string
+		for _, dep := range mig.DependsOn {
+			if ids[dep] {
+				dep = name + "/" + dep
+			}
+			deps = append(deps, dep)
+		}
+		mig.DependsOn = deps
+		var squashes []string
+		for _, id := range mig.Squashes {
+			squashes = append(squashes, name+"/"+id)
+		}
+		mig.Squashes = squashes
+		namespaced = append(namespaced, mig)
+	}
+	return namespaced
+}
+
+// mergeByVersion repeatedly takes first remaining migration of modules
+// with the smallest file name, see MergeByVersion.
+func mergeByVersion(modules [][]Migration) []Migration {
+	var merged []Migration
+	next := make([]int, len(modules))
+	for {
+		best := -1
+		for i, migs := range modules {
+			if next[i] == len(migs) {
+				continue
+			}
+			if best == -1 || path.Base(migs[next[i]].ID) < path.Base(modules[best][next[best]].ID) {
+				best = i
+			}
+		}
+		if best == -1 {
+			return merged
+		}
+		merged = append(merged, modules[best][next[best]])
+		next[best]++
+	}
+}
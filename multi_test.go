@@ -0,0 +1,91 @@
+package migrations_test
+
+import (
+	"strings"
+	"testing"
+
+	migrations "github.com/ulexxander/go-db-migrations"
+)
+
+func TestSourceMulti(t *testing.T) {
+	users := migrations.SourceDirect{
+		migrations.NewMigration("20230101_users.sql", "CREATE TABLE users (id int)"),
+		migrations.NewMigration("20230301_roles.sql", "-- migrate:depends 20230101_users.sql\nCREATE TABLE roles (id int)"),
+	}
+	billing := migrations.SourceDirect{
+		migrations.NewMigration("20230201_invoices.sql", "-- migrate:depends users/20230101_users.sql\nCREATE TABLE invoices (id int)"),
+		migrations.NewMigration("20230401_payments.sql", "-- migrate:depends 20230201_invoices.sql\nCREATE TABLE payments (id int)"),
+	}
+
+	src := migrations.SourceMulti{
+		Modules: []migrations.SourceModule{
+			{Name: "users", Source: users},
+			{Name: "billing", Source: billing},
+		},
+		Namespace: true,
+	}
+	migs, err := src.Migrations()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := "users/20230101_users.sql billing/20230201_invoices.sql users/20230301_roles.sql billing/20230401_payments.sql"
+	if ids := migrationIDs(migs); ids != expected {
+		t.Errorf("unexpected migrations: %s", ids)
+	}
+	if deps := strings.Join(migs[2].DependsOn, " "); deps != "users/20230101_users.sql" {
+		t.Errorf("unexpected dependencies of module migration: %s", deps)
+	}
+	if deps := strings.Join(migs[1].DependsOn, " "); deps != "users/20230101_users.sql" {
+		t.Errorf("unexpected dependencies on other module: %s", deps)
+	}
+	if _, err := migrations.SortByDependencies(migs); err != nil {
+		t.Errorf("could not sort merged migrations: %s", err)
+	}
+
+	src.Order = migrations.MergeBySource
+	migs, err = src.Migrations()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected = "users/20230101_users.sql users/20230301_roles.sql billing/20230201_invoices.sql billing/20230401_payments.sql"
+	if ids := migrationIDs(migs); ids != expected {
+		t.Errorf("unexpected migrations: %s", ids)
+	}
+
+	renames, err := src.NamespaceRenames()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(renames) != 4 || renames["20230201_invoices.sql"] != "billing/20230201_invoices.sql" {
+		t.Errorf("unexpected renames: %v", renames)
+	}
+}
+
+func TestSourceMultiUniqueIDs(t *testing.T) {
+	first := migrations.SourceDirect{migrations.NewMigration("001_init.sql", "SELECT 1")}
+	second := migrations.SourceDirect{migrations.NewMigration("001_init.sql", "SELECT 2")}
+
+	src := migrations.SourceMulti{
+		Modules: []migrations.SourceModule{
+			{Name: "users", Source: first},
+			{Name: "billing", Source: second},
+		},
+	}
+	_, err := src.Migrations()
+	if err == nil || err.Error() != "migration 001_init.sql is in modules users and billing" {
+		t.Errorf("expected duplicate error, got: %v", err)
+	}
+
+	src.Namespace = true
+	if _, err := src.Migrations(); err != nil {
+		t.Errorf("unexpected error with namespace: %s", err)
+	}
+	if _, err := src.NamespaceRenames(); err == nil {
+		t.Errorf("expected error renaming migration of several modules")
+	}
+
+	src.Modules[1].Name = "users"
+	if _, err := src.Migrations(); err == nil {
+		t.Errorf("expected error of duplicate module names")
+	}
+}
@@ -0,0 +1,58 @@
+package migrations
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Deploy phases of migrations, see Migration.Phase.
+const (
+	// PhasePre migrations are additive and run before new application version rolls out.
+	PhasePre = "pre"
+	// PhasePost migrations are destructive and run after old application version is gone.
+	PhasePost = "post"
+)
+
+// phase returns phase of migration, PhasePre if not specified.
+func (m Migration) phase() string {
+	if m.Phase == "" {
+		return PhasePre
+	}
+	return m.Phase
+}
+
+func validatePhase(phase string) error {
+	switch phase {
+	case PhasePre, PhasePost:
+		return nil
+	}
+	return fmt.Errorf("unknown phase %q, expected %s or %s", phase, PhasePre, PhasePost)
+}
+
+// dirPhase returns phase of file from its directory named like phase,
+// for example "post/003_drop_column.sql", or empty string.
+func dirPhase(relPath string) string {
+	var phase string
+	for _, dir := range strings.Split(path.Dir(relPath), "/") {
+		if dir == PhasePre || dir == PhasePost {
+			phase = dir
+		}
+	}
+	return phase
+}
+
+// OutstandingPhases returns phases having pending migrations, in order of deployment.
+func OutstandingPhases(statuses []MigrationStatus) []string {
+	pending := map[string]bool{}
+	for _, s := range FilterStatuses(statuses, StatePending) {
+		pending[s.Phase] = true
+	}
+	var phases []string
+	for _, phase := range []string{PhasePre, PhasePost} {
+		if pending[phase] {
+			phases = append(phases, phase)
+		}
+	}
+	return phases
+}
@@ -0,0 +1,118 @@
+package migrations_test
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	migrations "github.com/ulexxander/go-db-migrations"
+)
+
+func TestPhases(t *testing.T) {
+	db := DatabaseMock{}
+	src := migrations.SourceDirect{
+		migrations.NewMigration("1_add_column.sql", "SELECT 1"),
+		migrations.NewMigration("2_drop_column.sql", "-- migrate:phase post\nSELECT 1"),
+		migrations.NewMigration("3_add_table.sql", "-- migrate:phase pre\nSELECT 1"),
+	}
+	u := migrations.Upgrader{
+		Source:   &src,
+		Database: &db,
+	}
+
+	statuses, err := u.Status()
+	if err != nil {
+		t.Fatalf("could not get status: %s", err)
+	}
+	if phases := strings.Join(migrations.OutstandingPhases(statuses), " "); phases != "pre post" {
+		t.Errorf("expected pre and post phases outstanding, got: %s", phases)
+	}
+
+	u.Phase = migrations.PhasePost
+	if _, err := u.Do(); err == nil || !strings.Contains(err.Error(), "pre phase migrations are pending") {
+		t.Fatalf("expected post phase to fail while pre phase is pending, got: %v", err)
+	}
+
+	u.Phase = migrations.PhasePre
+	result, err := u.Do()
+	if err != nil {
+		t.Fatalf("could not run pre phase: %s", err)
+	}
+	resultEquals(t, result, []migrations.Migration{src[0], src[2]})
+
+	statuses, err = u.Status()
+	if err != nil {
+		t.Fatalf("could not get status: %s", err)
+	}
+	if phases := strings.Join(migrations.OutstandingPhases(statuses), " "); phases != "post" {
+		t.Errorf("expected post phase outstanding, got: %s", phases)
+	}
+
+	u.Phase = migrations.PhasePost
+	result, err = u.Do()
+	if err != nil {
+		t.Fatalf("could not run post phase: %s", err)
+	}
+	resultEquals(t, result, []migrations.Migration{src[1]})
+}
+
+func TestPhaseDependsOnOtherPhase(t *testing.T) {
+	db := DatabaseMock{}
+	src := migrations.SourceDirect{
+		migrations.NewMigration("001.sql", "-- migrate:phase post\nSELECT 1"),
+		migrations.NewMigration("002.sql", "-- migrate:depends 001.sql\nSELECT 1"),
+	}
+	u := migrations.Upgrader{
+		Source:   &src,
+		Database: &db,
+		Phase:    migrations.PhasePre,
+	}
+
+	_, err := u.Do()
+	if err == nil || !strings.Contains(err.Error(), "migration 002.sql of pre phase depends on pending migration 001.sql") {
+		t.Fatalf("expected error of dependency in other phase, got: %v", err)
+	}
+	if len(db.executed) != 0 {
+		t.Errorf("expected nothing to be executed, got: %d", len(db.executed))
+	}
+}
+
+func TestPhaseUnknown(t *testing.T) {
+	u := migrations.Upgrader{
+		Source: &migrations.SourceDirect{
+			migrations.NewMigration("1.sql", "-- migrate:phase later\nSELECT 1"),
+		},
+		Database: &DatabaseMock{},
+	}
+	if _, err := u.Do(); err == nil || !strings.Contains(err.Error(), `unknown phase "later"`) {
+		t.Fatalf("expected unknown phase error, got: %v", err)
+	}
+}
+
+func TestSourceFSPhaseDirectories(t *testing.T) {
+	fsys := fstest.MapFS{
+		"migrations/001_init.sql":            {Data: []byte("SELECT 1")},
+		"migrations/post/002_drop.sql":       {Data: []byte("SELECT 1")},
+		"migrations/post/003_pre_anyway.sql": {Data: []byte("-- migrate:phase pre\nSELECT 1")},
+	}
+	src := migrations.SourceFS{FS: fsys, Dir: "migrations"}
+
+	migs, err := src.Migrations()
+	if err != nil {
+		t.Fatalf("could not read migrations: %s", err)
+	}
+	phases := map[string]string{}
+	for _, mig := range migs {
+		phases[mig.ID] = mig.Phase
+	}
+	expected := map[string]string{
+		"001_init.sql":       "",
+		"002_drop.sql":       migrations.PhasePost,
+		"003_pre_anyway.sql": migrations.PhasePre,
+	}
+	for id, phase := range expected {
+		if phases[id] != phase {
+			t.Errorf("expected %s to have phase %q, got: %q", id, phase, phases[id])
+		}
+	}
+}
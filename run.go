@@ -0,0 +1,165 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+)
+
+// PendingPolicy decides what Run does with pending migrations.
+type PendingPolicy int
+
+const (
+	// PendingApply executes pending migrations.
+	PendingApply PendingPolicy = iota
+	// PendingFail returns ErrPending without executing them.
+	PendingFail
+	// PendingWarn logs warning without executing them.
+	PendingWarn
+)
+
+// ErrPending is returned by Run with PendingFail policy.
+var ErrPending = errors.New("there are pending migrations")
+
+type runOptions struct {
+	source  Source
+	logger  Logger
+	lock    bool
+	wait    *Backoff
+	pending PendingPolicy
+	phase   string
+	include []string
+	exclude []string
+}
+
+// Option configures Run.
+type Option func(*runOptions)
+
+// WithSource sets migrations source.
+func WithSource(src Source) Option {
+	return func(o *runOptions) {
+		o.source = src
+	}
+}
+
+// WithFS sets migrations source to directory in file system,
+// for example embed.FS, see SourceFS.
+func WithFS(fsys fs.FS, dir string) Option {
+	return WithSource(&SourceFS{FS: fsys, Dir: dir})
+}
+
+// WithLogger sets logger.
+func WithLogger(logger Logger) Option {
+	return func(o *runOptions) {
+		o.logger = logger
+	}
+}
+
+// WithLock enables or disables advisory lock preventing
+// concurrent upgrades, enabled by default. Lock needs database pool
+// of at least two connections, see LockPostgres.
+func WithLock(enabled bool) Option {
+	return func(o *runOptions) {
+		o.lock = enabled
+	}
+}
+
+// WithWait makes Run wait for database to become available
+// and retry upgrade when connection fails.
+func WithWait(backoff Backoff) Option {
+	return func(o *runOptions) {
+		o.wait = &backoff
+	}
+}
+
+// WithPendingPolicy sets what to do with pending migrations, PendingApply by default.
+func WithPendingPolicy(policy PendingPolicy) Option {
+	return func(o *runOptions) {
+		o.pending = policy
+	}
+}
+
+// WithPhase limits Run to migrations of given phase, see Upgrader.Phase.
+func WithPhase(phase string) Option {
+	return func(o *runOptions) {
+		o.phase = phase
+	}
+}
+
+// WithTags selects tagged migrations, see Upgrader.IncludeTags and Upgrader.ExcludeTags.
+func WithTags(include, exclude []string) Option {
+	return func(o *runOptions) {
+		o.include = include
+		o.exclude = exclude
+	}
+}
+
+// Run migrates postgres database, meant to be called on application startup.
+// Source must be specified with WithSource or WithFS.
+func Run(ctx context.Context, db *sql.DB, opts ...Option) (*UpgradeResult, error) {
+	o := runOptions{
+		lock: true,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.logger == nil {
+		o.logger = nopLogger{}
+	}
+
+	if o.source == nil {
+		return nil, errors.New("migrations source is not specified")
+	}
+
+	if o.wait != nil {
+		if err := WaitForDB(ctx, db, *o.wait, o.logger); err != nil {
+			return nil, fmt.Errorf("database is not available: %w", err)
+		}
+	}
+
+	if o.lock {
+		unlock, err := LockPostgres(ctx, db)
+		if err != nil {
+			return nil, err
+		}
+		defer func() {
+			if err := unlock(); err != nil {
+				o.logger.Error("Could not release migrations lock", "error", err)
+			}
+		}()
+	}
+
+	u := Upgrader{
+		Logger:      o.logger,
+		Source:      o.source,
+		Database:    &DatabasePostgres{DB: db},
+		Phase:       o.phase,
+		IncludeTags: o.include,
+		ExcludeTags: o.exclude,
+	}
+
+	switch o.pending {
+	case PendingFail, PendingWarn:
+		pending, err := u.Plan()
+		if err != nil {
+			return nil, err
+		}
+		if len(pending) == 0 {
+			return &UpgradeResult{}, nil
+		}
+		if o.pending == PendingFail {
+			return nil, fmt.Errorf("%w: %d, first is %s", ErrPending, len(pending), pending[0].ID)
+		}
+		for _, mig := range pending {
+			o.logger.Warn("Migration is pending", "id", mig.ID)
+		}
+		return &UpgradeResult{}, nil
+	}
+
+	if o.wait != nil {
+		return u.DoWithRetry(ctx, *o.wait)
+	}
+	return u.Do()
+}
@@ -0,0 +1,109 @@
+package migrations_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	migrations "github.com/ulexxander/go-db-migrations"
+)
+
+var runFS = fstest.MapFS{
+	"migrations/1.sql": {Data: []byte("CREATE TABLE first ( somefield TEXT NOT NULL )")},
+	"migrations/2.sql": {Data: []byte("CREATE TABLE second ( somefield TEXT NOT NULL )")},
+}
+
+func TestRun(t *testing.T) {
+	db, err := openDB()
+	if err != nil {
+		t.Fatalf("could not setup db: %s", err)
+	}
+	defer db.Close()
+	if err := resetDB(db); err != nil {
+		t.Fatalf("could not reset db: %s", err)
+	}
+
+	ctx := context.Background()
+
+	_, err = migrations.Run(ctx, db,
+		migrations.WithFS(runFS, "migrations"),
+		migrations.WithPendingPolicy(migrations.PendingFail),
+	)
+	if !errors.Is(err, migrations.ErrPending) {
+		t.Fatalf("expected to get pending error, got: %v", err)
+	}
+
+	result, err := migrations.Run(ctx, db,
+		migrations.WithFS(runFS, "migrations"),
+		migrations.WithWait(migrations.Backoff{}),
+	)
+	if err != nil {
+		t.Fatalf("unexpected run error: %s", err)
+	}
+	if len(result.Executed) != 2 {
+		t.Fatalf("expected to execute 2 migrations, got: %d", len(result.Executed))
+	}
+
+	result, err = migrations.Run(ctx, db,
+		migrations.WithFS(runFS, "migrations"),
+		migrations.WithPendingPolicy(migrations.PendingFail),
+	)
+	if err != nil {
+		t.Fatalf("unexpected run error when nothing is pending: %s", err)
+	}
+	if len(result.Executed) != 0 {
+		t.Fatalf("expected nothing to be executed, got: %d", len(result.Executed))
+	}
+}
+
+func TestRunSelectsMigrations(t *testing.T) {
+	db, err := openDB()
+	if err != nil {
+		t.Fatalf("could not setup db: %s", err)
+	}
+	defer db.Close()
+	if err := resetDB(db); err != nil {
+		t.Fatalf("could not reset db: %s", err)
+	}
+
+	fsys := fstest.MapFS{
+		"migrations/1.sql":       {Data: []byte("CREATE TABLE first ( somefield TEXT NOT NULL )")},
+		"migrations/@test/2.sql": {Data: []byte("CREATE TABLE second ( somefield TEXT NOT NULL )")},
+		"migrations/post/3.sql":  {Data: []byte("DROP TABLE first")},
+	}
+	result, err := migrations.Run(context.Background(), db,
+		migrations.WithFS(fsys, "migrations"),
+		migrations.WithTags(nil, []string{"test"}),
+		migrations.WithPhase(migrations.PhasePre),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(result.Executed) != 1 || result.Executed[0].ID != "1.sql" {
+		t.Errorf("expected only untagged pre phase migration, got: %+v", result.Executed)
+	}
+}
+
+func TestRunLockSingleConnection(t *testing.T) {
+	// pool is checked before connecting
+	db, err := sql.Open("postgres", "host=localhost")
+	if err != nil {
+		t.Fatalf("could not open db: %s", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	_, err = migrations.Run(context.Background(), db, migrations.WithFS(runFS, "migrations"))
+	if err == nil || !strings.Contains(err.Error(), "single open connection") {
+		t.Fatalf("expected error of single connection pool with lock, got: %v", err)
+	}
+}
+
+func TestRunRequiresSource(t *testing.T) {
+	if _, err := migrations.Run(context.Background(), nil); err == nil {
+		t.Fatalf("expected to get error without source, got nil")
+	}
+}
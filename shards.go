@@ -0,0 +1,162 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// ErrDiverged is returned by ShardsRunner when shards executed different migrations.
+var ErrDiverged = errors.New("shards diverged")
+
+// Shard is postgres database migrated by ShardsRunner.
+type Shard struct {
+	// Name identifies shard in results and logs, DSN is never logged.
+	Name string
+	DSN  string
+}
+
+// ShardDivergence lists migrations executed in some shards, but not in this one.
+type ShardDivergence struct {
+	Shard   string
+	Missing []string
+}
+
+// ShardsReport is result of ShardsRunner.
+type ShardsReport struct {
+	// Results are in order of shards, target of each one is shard name.
+	Results []TargetResult
+	// Executed are IDs of versioned migrations executed in each shard after upgrade,
+	// by shard name. Skipped and unreachable shards are absent.
+	Executed map[string][]string
+	// Divergent are shards missing migrations executed in other shards.
+	Divergent []ShardDivergence
+}
+
+// ShardsRunner applies the same migrations to many postgres databases,
+// so that all of them converge to the same version.
+type ShardsRunner struct {
+	Shards []Shard
+	Source Source
+	Logger Logger
+	// Schema keeping migrations tables in every shard, see DatabasePostgres.Schema.
+	Schema string
+	// Concurrency is max number of shards migrated at once, defaults to 1.
+	Concurrency int
+	// ContinueOnError keeps migrating other shards after failure.
+	ContinueOnError bool
+	// Phase, IncludeTags and ExcludeTags select migrations, see Upgrader.
+	Phase       string
+	IncludeTags []string
+	ExcludeTags []string
+}
+
+// Run migrates all shards and compares their executed migrations afterwards.
+// Error is returned when migrations failed in any shard,
+// or wraps ErrDiverged when shards executed different migrations.
+func (sr *ShardsRunner) Run(ctx context.Context) (*ShardsReport, error) {
+	logger := sr.Logger
+	if logger == nil {
+		logger = nopLogger{}
+	}
+
+	dsns := map[string]string{}
+	var names []string
+	for i, shard := range sr.Shards {
+		name := shard.Name
+		if name == "" {
+			name = fmt.Sprintf("shard-%d", i)
+		}
+		if _, ok := dsns[name]; ok {
+			return nil, fmt.Errorf("duplicate shard name: %s", name)
+		}
+		dsns[name] = shard.DSN
+		names = append(names, name)
+	}
+
+	report := ShardsReport{Executed: map[string][]string{}}
+	var mu sync.Mutex
+
+	report.Results = FanOut(ctx, names, sr.Concurrency, sr.ContinueOnError, func(ctx context.Context, name string) (*UpgradeResult, error) {
+		db, err := sql.Open("postgres", dsns[name])
+		if err != nil {
+			return nil, fmt.Errorf("could not open database: %w", err)
+		}
+		defer db.Close()
+
+		dp := DatabasePostgres{DB: db, Schema: sr.Schema}
+		u := Upgrader{
+			Logger:      &fieldsLogger{Logger: logger, keyvals: []interface{}{"shard", name}},
+			Source:      sr.Source,
+			Database:    &dp,
+			Phase:       sr.Phase,
+			IncludeTags: sr.IncludeTags,
+			ExcludeTags: sr.ExcludeTags,
+		}
+		result, err := u.Do()
+
+		// executed set is compared even after failure, showing how far shard got
+		if executed, execErr := dp.ExecutedMigrations(); execErr == nil {
+			ids := make([]string, 0, len(executed))
+			for _, e := range executed {
+				ids = append(ids, e.ID)
+			}
+			sort.Strings(ids)
+			mu.Lock()
+			report.Executed[name] = ids
+			mu.Unlock()
+		}
+
+		return result, err
+	})
+
+	report.Divergent = divergence(names, report.Executed)
+
+	err := logResults(logger, "shard", report.Results)
+	for _, d := range report.Divergent {
+		logger.Warn("Shard diverged", "shard", d.Shard, "missing", d.Missing)
+	}
+	if err != nil {
+		return &report, err
+	}
+	if len(report.Divergent) > 0 {
+		return &report, fmt.Errorf("%w: %d of %d shards miss migrations executed in others", ErrDiverged, len(report.Divergent), len(report.Results))
+	}
+	return &report, nil
+}
+
+// divergence compares executed sets of shards with their union.
+func divergence(names []string, executed map[string][]string) []ShardDivergence {
+	union := map[string]struct{}{}
+	for _, ids := range executed {
+		for _, id := range ids {
+			union[id] = struct{}{}
+		}
+	}
+
+	var result []ShardDivergence
+	for _, name := range names {
+		ids, ok := executed[name]
+		if !ok {
+			continue
+		}
+		has := make(map[string]struct{}, len(ids))
+		for _, id := range ids {
+			has[id] = struct{}{}
+		}
+		var missing []string
+		for id := range union {
+			if _, ok := has[id]; !ok {
+				missing = append(missing, id)
+			}
+		}
+		if len(missing) > 0 {
+			sort.Strings(missing)
+			result = append(result, ShardDivergence{Shard: name, Missing: missing})
+		}
+	}
+	return result
+}
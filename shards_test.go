@@ -0,0 +1,146 @@
+package migrations_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	migrations "github.com/ulexxander/go-db-migrations"
+)
+
+func TestShardsRunnerStopsOnError(t *testing.T) {
+	unreachable := "host=127.0.0.1 port=1 sslmode=disable connect_timeout=1"
+	sr := migrations.ShardsRunner{
+		Shards: []migrations.Shard{
+			{Name: "first", DSN: unreachable},
+			{Name: "second", DSN: unreachable},
+		},
+		Source: migrations.SourceDirect{
+			migrations.NewMigration("1_first.sql", "CREATE TABLE first (id int)"),
+		},
+	}
+
+	report, err := sr.Run(context.Background())
+	if err == nil {
+		t.Fatalf("expected error")
+	}
+	summary := migrations.Summarize(report.Results)
+	if summary != (migrations.FanOutSummary{Failed: 1, Skipped: 1}) {
+		t.Errorf("unexpected summary: %+v", summary)
+	}
+	if len(report.Executed) != 0 {
+		t.Errorf("expected no executed sets of unreachable shards, got: %v", report.Executed)
+	}
+}
+
+func TestShardsRunnerDuplicateNames(t *testing.T) {
+	sr := migrations.ShardsRunner{
+		Shards: []migrations.Shard{{Name: "a"}, {Name: "a"}},
+	}
+	if _, err := sr.Run(context.Background()); err == nil {
+		t.Fatalf("expected error")
+	}
+}
+
+func shardDSN(schema string) string {
+	return fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=%s options='-c search_path=%s'",
+		pgHost, pgPort, pgUser, pgPass, pgDBName, pgSSLMode, schema)
+}
+
+func TestShardsRunnerDetectsDivergence(t *testing.T) {
+	db, err := openDB()
+	if err != nil {
+		t.Fatalf("could not setup db: %s", err)
+	}
+	defer db.Close()
+
+	// every shard is schema in test database, selected by search_path of its connection
+	shardSchemas := []string{"shard_a", "shard_b"}
+	var shards []migrations.Shard
+	for _, schema := range shardSchemas {
+		if _, err := db.Exec("DROP SCHEMA IF EXISTS " + schema + " CASCADE"); err != nil {
+			t.Fatalf("could not drop schema %s: %s", schema, err)
+		}
+		if _, err := db.Exec("CREATE SCHEMA " + schema); err != nil {
+			t.Fatalf("could not create schema %s: %s", schema, err)
+		}
+		shards = append(shards, migrations.Shard{Name: schema, DSN: shardDSN(schema)})
+	}
+
+	first := migrations.NewMigration("1_first.sql", "CREATE TABLE first (id int)")
+	sr := migrations.ShardsRunner{
+		Shards:      shards,
+		Source:      migrations.SourceDirect{first},
+		Concurrency: 2,
+	}
+
+	report, err := sr.Run(context.Background())
+	if err != nil {
+		t.Fatalf("could not migrate shards: %s", err)
+	}
+	for _, schema := range shardSchemas {
+		if ids := report.Executed[schema]; len(ids) != 1 || ids[0] != first.ID {
+			t.Errorf("unexpected executed migrations of %s: %v", schema, ids)
+		}
+	}
+
+	// shard_a gets migration unknown to the others
+	u := migrations.Upgrader{
+		Source: migrations.SourceDirect{
+			first,
+			migrations.NewMigration("2_second.sql", "CREATE TABLE second (id int)"),
+		},
+		Database: &migrations.DatabasePostgres{DB: db, Schema: "shard_a"},
+	}
+	if _, err := u.Do(); err != nil {
+		t.Fatalf("could not migrate shard_a: %s", err)
+	}
+
+	report, err = sr.Run(context.Background())
+	if !errors.Is(err, migrations.ErrDiverged) {
+		t.Fatalf("expected diverged error, got: %v", err)
+	}
+	if len(report.Divergent) != 1 {
+		t.Fatalf("expected 1 divergent shard, got: %+v", report.Divergent)
+	}
+	d := report.Divergent[0]
+	if d.Shard != "shard_b" || len(d.Missing) != 1 || d.Missing[0] != "2_second.sql" {
+		t.Errorf("unexpected divergence: %+v", d)
+	}
+}
+
+func TestShardsRunnerSelectsMigrations(t *testing.T) {
+	db, err := openDB()
+	if err != nil {
+		t.Fatalf("could not setup db: %s", err)
+	}
+	defer db.Close()
+
+	schema := "shard_tags"
+	if _, err := db.Exec("DROP SCHEMA IF EXISTS " + schema + " CASCADE"); err != nil {
+		t.Fatalf("could not drop schema %s: %s", schema, err)
+	}
+	if _, err := db.Exec("CREATE SCHEMA " + schema); err != nil {
+		t.Fatalf("could not create schema %s: %s", schema, err)
+	}
+
+	sr := migrations.ShardsRunner{
+		Shards: []migrations.Shard{{Name: schema, DSN: shardDSN(schema)}},
+		Source: migrations.SourceDirect{
+			migrations.NewMigration("1_first.sql", "CREATE TABLE first (id int)"),
+			migrations.NewMigration("2_fixtures.sql", "-- migrate:tags test\nCREATE TABLE fixtures (id int)"),
+			migrations.NewMigration("3_cleanup.sql", "-- migrate:phase post\nCREATE TABLE cleanup (id int)"),
+		},
+		Phase:       migrations.PhasePre,
+		ExcludeTags: []string{"test"},
+	}
+
+	report, err := sr.Run(context.Background())
+	if err != nil {
+		t.Fatalf("could not migrate shards: %s", err)
+	}
+	if ids := report.Executed[schema]; len(ids) != 1 || ids[0] != "1_first.sql" {
+		t.Errorf("expected only untagged pre phase migration, got: %v", ids)
+	}
+}
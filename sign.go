@@ -0,0 +1,235 @@
+package migrations
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// signatureFile is stored in root of signed bundle, next to manifest.
+const signatureFile = "manifest.sig"
+
+// ErrUntrusted is returned by SourceSigned when migrations are not signed by any of trusted keys.
+var ErrUntrusted = errors.New("migrations are not signed by trusted key")
+
+// signedPayload is content covered by signature of manifest.
+func (m *Manifest) signedPayload() ([]byte, error) {
+	payload := struct {
+		Version    string          `json:"version"`
+		Migrations []ManifestEntry `json:"migrations"`
+	}{m.Version, m.Migrations}
+	return json.Marshal(payload)
+}
+
+// SignManifest signs version, IDs and checksums of migrations listed in manifest.
+func SignManifest(m *Manifest, key ed25519.PrivateKey) ([]byte, error) {
+	payload, err := m.signedPayload()
+	if err != nil {
+		return nil, fmt.Errorf("could not encode manifest: %w", err)
+	}
+	return ed25519.Sign(key, payload), nil
+}
+
+// VerifyManifest checks that signature of manifest was made by any of keys.
+func VerifyManifest(m *Manifest, signature []byte, keys []ed25519.PublicKey) error {
+	if len(keys) == 0 {
+		return errors.New("no public keys to verify signature with")
+	}
+	payload, err := m.signedPayload()
+	if err != nil {
+		return fmt.Errorf("could not encode manifest: %w", err)
+	}
+	for _, key := range keys {
+		if ed25519.Verify(key, payload, signature) {
+			return nil
+		}
+	}
+	return ErrUntrusted
+}
+
+func readSignature(fsys fs.FS) ([]byte, error) {
+	content, err := fs.ReadFile(fsys, signatureFile)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("could not read signature: %w", err)
+	}
+	signature, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(content)))
+	if err != nil {
+		return nil, fmt.Errorf("could not decode signature: %w", err)
+	}
+	return signature, nil
+}
+
+// Sign verifies archive against its manifest and stores signature of manifest in archive,
+// replacing previous one. Archive is replaced atomically.
+func (sa *SourceArchive) Sign(key ed25519.PrivateKey) (*Manifest, error) {
+	fsys, err := openArchive(sa.Path)
+	if err != nil {
+		return nil, fmt.Errorf("could not open archive %s: %w", sa.Path, err)
+	}
+	files, err := readFiles(fsys)
+	if closer, ok := fsys.(io.Closer); ok {
+		closer.Close()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read archive %s: %w", sa.Path, err)
+	}
+	delete(files, signatureFile)
+
+	// contents are verified as they are written back
+	signing := *sa
+	signing.RequireManifest = true
+	contents, err := signing.read(files)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := SignManifest(contents.manifest, key)
+	if err != nil {
+		return nil, err
+	}
+	files[signatureFile] = []byte(base64.StdEncoding.EncodeToString(signature) + "\n")
+
+	format, err := ArchiveFormat(sa.Path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(sa.Path)
+	if err != nil {
+		return nil, fmt.Errorf("could not stat archive: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(sa.Path), ".sign-*")
+	if err != nil {
+		return nil, fmt.Errorf("could not create archive: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(info.Mode().Perm()); err != nil {
+		tmp.Close()
+		return nil, fmt.Errorf("could not create archive: %w", err)
+	}
+
+	switch format {
+	case ArchiveTarGz:
+		err = writeTarGz(tmp, files, contents.manifest.CreatedAt)
+	case ArchiveZip:
+		err = writeZip(tmp, files, contents.manifest.CreatedAt)
+	}
+	if closeErr := tmp.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("could not write archive: %w", closeErr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp.Name(), sa.Path); err != nil {
+		return nil, fmt.Errorf("could not replace archive: %w", err)
+	}
+	return contents.manifest, nil
+}
+
+// readFiles reads all regular files of fsys to memory.
+func readFiles(fsys fs.FS) (memFS, error) {
+	files := memFS{}
+	err := fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		content, err := fs.ReadFile(fsys, p)
+		if err != nil {
+			return err
+		}
+		files[p] = content
+		return nil
+	})
+	return files, err
+}
+
+// SourceSigned returns migrations of archive only when its manifest
+// is signed by any of PublicKeys, see SourceArchive.Sign.
+// Archive is verified to match manifest on each read.
+type SourceSigned struct {
+	Archive    *SourceArchive
+	PublicKeys []ed25519.PublicKey
+}
+
+func (ss *SourceSigned) Migrations() ([]Migration, error) {
+	contents, err := ss.verified()
+	if err != nil {
+		return nil, err
+	}
+	return contents.migs, nil
+}
+
+// Manifest returns manifest of archive after verifying its signature.
+func (ss *SourceSigned) Manifest() (*Manifest, error) {
+	contents, err := ss.verified()
+	if err != nil {
+		return nil, err
+	}
+	return contents.manifest, nil
+}
+
+func (ss *SourceSigned) verified() (*archiveContents, error) {
+	contents, err := ss.Archive.load()
+	if err != nil {
+		return nil, err
+	}
+	if contents.manifest == nil {
+		return nil, fmt.Errorf("archive %s has no manifest: %w", ss.Archive.Path, ErrUntrusted)
+	}
+	if contents.signature == nil {
+		return nil, fmt.Errorf("archive %s is not signed: %w", ss.Archive.Path, ErrUntrusted)
+	}
+	if err := VerifyManifest(contents.manifest, contents.signature, ss.PublicKeys); err != nil {
+		return nil, fmt.Errorf("archive %s: %w", ss.Archive.Path, err)
+	}
+	return contents, nil
+}
+
+// ParsePrivateKey parses PEM encoded PKCS #8 ed25519 private key,
+// like one generated by "openssl genpkey -algorithm ed25519".
+func ParsePrivateKey(data []byte) (ed25519.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("could not decode private key: no PEM block")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse private key: %w", err)
+	}
+	edKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("private key is %T, expected ed25519", key)
+	}
+	return edKey, nil
+}
+
+// ParsePublicKey parses PEM encoded PKIX ed25519 public key,
+// like one extracted by "openssl pkey -pubout".
+func ParsePublicKey(data []byte) (ed25519.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("could not decode public key: no PEM block")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse public key: %w", err)
+	}
+	edKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("public key is %T, expected ed25519", key)
+	}
+	return edKey, nil
+}
@@ -0,0 +1,203 @@
+package migrations_test
+
+import (
+	"archive/zip"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	migrations "github.com/ulexxander/go-db-migrations"
+)
+
+func generateKey(t *testing.T) (ed25519.PublicKey, ed25519.PrivateKey) {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("could not generate key: %s", err)
+	}
+	return pub, priv
+}
+
+func TestSourceSigned(t *testing.T) {
+	pub, priv := generateKey(t)
+	otherPub, otherPriv := generateKey(t)
+
+	for _, name := range []string{"bundle.tar.gz", "bundle.zip"} {
+		t.Run(name, func(t *testing.T) {
+			archivePath, _ := writeBundle(t, name)
+			archive := &migrations.SourceArchive{Path: archivePath}
+			src := migrations.SourceSigned{Archive: archive, PublicKeys: []ed25519.PublicKey{otherPub, pub}}
+
+			if _, err := src.Migrations(); !errors.Is(err, migrations.ErrUntrusted) {
+				t.Fatalf("expected untrusted error of unsigned archive, got: %v", err)
+			}
+
+			manifest, err := archive.Sign(priv)
+			if err != nil {
+				t.Fatalf("could not sign archive: %s", err)
+			}
+			if manifest.Version != "1.2.0" {
+				t.Errorf("unexpected manifest: %+v", manifest)
+			}
+
+			migs, err := src.Migrations()
+			if err != nil {
+				t.Fatalf("could not read signed archive: %s", err)
+			}
+			if len(migs) != 2 {
+				t.Errorf("unexpected migrations: %s", migrationIDs(migs))
+			}
+
+			// signature made by key that is not trusted
+			if _, err := archive.Sign(otherPriv); err != nil {
+				t.Fatalf("could not sign archive: %s", err)
+			}
+			src.PublicKeys = []ed25519.PublicKey{pub}
+			if _, err := src.Migrations(); !errors.Is(err, migrations.ErrUntrusted) {
+				t.Errorf("expected untrusted error, got: %v", err)
+			}
+		})
+	}
+}
+
+// moveInZip rewrites zip archive with file moved from one path to other.
+func moveInZip(t *testing.T, archivePath, from, to string) {
+	t.Helper()
+	zr, err := zip.OpenReader(archivePath)
+	if err != nil {
+		t.Fatalf("could not open archive: %s", err)
+	}
+	files := map[string]string{}
+	for _, f := range zr.File {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatalf("could not open %s: %s", f.Name, err)
+		}
+		content, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatalf("could not read %s: %s", f.Name, err)
+		}
+		name := f.Name
+		if name == from {
+			name = to
+		}
+		files[name] = string(content)
+	}
+	zr.Close()
+
+	moved := writeZip(t, files)
+	content, err := os.ReadFile(moved)
+	if err != nil {
+		t.Fatalf("could not read archive: %s", err)
+	}
+	if err := os.WriteFile(archivePath, content, 0644); err != nil {
+		t.Fatalf("could not write archive: %s", err)
+	}
+}
+
+func TestSourceSignedMovedFile(t *testing.T) {
+	pub, priv := generateKey(t)
+	fsys := fstest.MapFS{
+		"db/001_init.sql":  {Data: []byte("CREATE TABLE users (id int);")},
+		"db/@test/900.sql": {Data: []byte("INSERT INTO users VALUES (1);")},
+		"db/post/950.sql":  {Data: []byte("DROP TABLE legacy;")},
+	}
+
+	for _, tc := range []struct{ from, to string }{
+		{"migrations/@test/900.sql", "migrations/900.sql"},
+		{"migrations/post/950.sql", "migrations/950.sql"},
+	} {
+		archivePath := filepath.Join(t.TempDir(), "bundle.zip")
+		f, err := os.Create(archivePath)
+		if err != nil {
+			t.Fatalf("could not create archive: %s", err)
+		}
+		_, err = migrations.Bundle(f, migrations.ArchiveZip, &migrations.SourceFS{FS: fsys, Dir: "db"}, "1")
+		f.Close()
+		if err != nil {
+			t.Fatalf("could not bundle migrations: %s", err)
+		}
+		archive := &migrations.SourceArchive{Path: archivePath}
+		if _, err := archive.Sign(priv); err != nil {
+			t.Fatalf("could not sign archive: %s", err)
+		}
+		src := migrations.SourceSigned{Archive: archive, PublicKeys: []ed25519.PublicKey{pub}}
+		if _, err := src.Migrations(); err != nil {
+			t.Fatalf("could not read signed archive: %s", err)
+		}
+
+		moveInZip(t, archivePath, tc.from, tc.to)
+		_, err = src.Migrations()
+		if err == nil || !strings.Contains(err.Error(), "does not match its manifest") {
+			t.Errorf("expected error of %s moved to %s, got: %v", tc.from, tc.to, err)
+		}
+	}
+}
+
+func TestVerifyManifest(t *testing.T) {
+	pub, priv := generateKey(t)
+	manifest := migrations.Manifest{
+		Version: "1",
+		Migrations: []migrations.ManifestEntry{
+			{ID: "001.sql", Checksum: "abc"},
+			{ID: "002.sql", Checksum: "def"},
+		},
+	}
+
+	signature, err := migrations.SignManifest(&manifest, priv)
+	if err != nil {
+		t.Fatalf("could not sign manifest: %s", err)
+	}
+	if err := migrations.VerifyManifest(&manifest, signature, []ed25519.PublicKey{pub}); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+
+	manifest.Migrations[1].Checksum = "xyz"
+	if err := migrations.VerifyManifest(&manifest, signature, []ed25519.PublicKey{pub}); !errors.Is(err, migrations.ErrUntrusted) {
+		t.Errorf("expected untrusted error of changed checksum, got: %v", err)
+	}
+	if err := migrations.VerifyManifest(&manifest, signature, nil); err == nil {
+		t.Errorf("expected error without keys")
+	}
+}
+
+func TestParseKeys(t *testing.T) {
+	pub, priv := generateKey(t)
+
+	privDER, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		t.Fatalf("could not marshal private key: %s", err)
+	}
+	parsedPriv, err := migrations.ParsePrivateKey(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privDER}))
+	if err != nil {
+		t.Fatalf("could not parse private key: %s", err)
+	}
+	if !parsedPriv.Equal(priv) {
+		t.Errorf("parsed private key differs")
+	}
+
+	pubDER, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		t.Fatalf("could not marshal public key: %s", err)
+	}
+	parsedPub, err := migrations.ParsePublicKey(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER}))
+	if err != nil {
+		t.Fatalf("could not parse public key: %s", err)
+	}
+	if !parsedPub.Equal(pub) {
+		t.Errorf("parsed public key differs")
+	}
+
+	if _, err := migrations.ParsePublicKey([]byte("not a key")); err == nil {
+		t.Errorf("expected error of invalid key")
+	}
+}
@@ -1,65 +1,322 @@
 package migrations
 
 import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"io/fs"
 	"os"
-	"path/filepath"
+	"path"
+	"strings"
 )
 
 type Migration struct {
 	ID      string
 	Content string
+	// Checksum of the content, see Checksum func.
+	// Upgrader computes it if empty.
+	Checksum string
+	// Repeatable migrations are executed again each time their checksum changes,
+	// always after all versioned migrations.
+	Repeatable bool
+	// Squashes lists IDs of migrations replaced by this one, see Squasher.
+	Squashes []string
+	// DependsOn lists IDs of migrations which must be executed before this one,
+	// see SortByDependencies.
+	DependsOn []string
+	// Phase is PhasePre (default) or PhasePost,
+	// set with directive like "-- migrate:phase post" or by directory, see SourceFS.
+	Phase string
+	// Tags select environments migration runs in, see Upgrader.IncludeTags.
+	// Set with directive like "-- migrate:tags seed, dev" or by directory, see SourceFS.
+	Tags []string
+	// Open returns content of streamed migration, which is not kept in Content,
+	// see SourceFS.StreamThreshold. Nil for migrations with Content.
+	// Content is rendered with variables and reading it fails at its end
+	// when file no longer matches Checksum.
+	Open func() (io.ReadCloser, error)
+}
+
+// repeatablePrefix marks migration file as repeatable, like R__refresh_views.sql.
+const repeatablePrefix = "R__"
+
+const directivePrefix = "-- migrate:"
+
+// NewMigration creates migration with checksum and options
+// derived from its ID and header directives.
+// Directives are comment lines at the top of migration content,
+// for example "-- migrate:repeatable".
+func NewMigration(id, content string) Migration {
+	directives := parseDirectives(content)
+	_, repeatable := directives["repeatable"]
+	return Migration{
+		ID:         id,
+		Content:    content,
+		Checksum:   Checksum(content),
+		Repeatable: repeatable || strings.HasPrefix(id, repeatablePrefix),
+		Squashes:   directiveList(directives["squashes"]),
+		DependsOn:  directiveList(directives["depends"]),
+		Phase:      directives["phase"],
+		Tags:       directiveList(directives["tags"]),
+	}
+}
+
+// Checksum returns hex encoded SHA-256 of migration content.
+func Checksum(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+// parseDirectives reads directives from leading comment and blank lines.
+// Returned map is keyed by directive name with the rest of the line as value.
+func parseDirectives(content string) map[string]string {
+	directives := map[string]string{}
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if !strings.HasPrefix(line, "--") {
+			break
+		}
+		if !strings.HasPrefix(line, directivePrefix) {
+			continue
+		}
+		name, value, _ := cut(strings.TrimPrefix(line, directivePrefix), " ")
+		directives[name] = strings.TrimSpace(value)
+	}
+	return directives
+}
+
+// directiveList splits directive value separated by commas or spaces.
+func directiveList(value string) []string {
+	return strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t'
+	})
+}
+
+// cut is strings.Cut, which is not available in go 1.17.
+func cut(s, sep string) (before, after string, found bool) {
+	if i := strings.Index(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
 }
 
 type Source interface {
 	Migrations() ([]Migration, error)
 }
 
+// SourceDir reads migrations from directory, see SourceFS.
 type SourceDir struct {
 	Dir string
+	// Vars are substituted for ${name} placeholders, see SourceFS.
+	Vars map[string]string
+	// Patterns and NonRecursive select migration files, see SourceFS.
+	Patterns     []string
+	NonRecursive bool
+	// IDScheme and StripExtension define migration IDs, see SourceFS.
+	IDScheme       IDScheme
+	StripExtension bool
+	// StreamThreshold is size of files streamed instead of read to memory, see SourceFS.
+	StreamThreshold int64
 }
 
 func (sr *SourceDir) Migrations() ([]Migration, error) {
-	paths, err := sr.filepaths()
+	migrations, err := sr.sourceFS().Migrations()
+	if err != nil {
+		return nil, fmt.Errorf("could not read directory %s: %w", sr.Dir, err)
+	}
+	return migrations, nil
+}
+
+func (sr *SourceDir) sourceFS() *SourceFS {
+	return &SourceFS{
+		FS:              os.DirFS(sr.Dir),
+		Vars:            sr.Vars,
+		Patterns:        sr.Patterns,
+		NonRecursive:    sr.NonRecursive,
+		IDScheme:        sr.IDScheme,
+		StripExtension:  sr.StripExtension,
+		StreamThreshold: sr.StreamThreshold,
+	}
+}
+
+// SourceFS reads migrations from file system, for example embed.FS.
+// Files are read in lexical order, their base names are migration IDs by default, see IDScheme.
+// Files in directories named "pre" or "post" belong to that phase, see Migration.Phase,
+// files in directories like "@seed" are tagged with their name, see Migration.Tags.
+// Files and directories prefixed with "_" keep fragments included by migrations
+// with "-- migrate:include" directive and are not migrations.
+// Hidden files and directories, and ones listed in .migrationsignore file are skipped,
+// other files not matching Patterns are errors, so that they are never executed by mistake.
+type SourceFS struct {
+	FS fs.FS
+	// Dir is migrations directory inside FS, defaults to its root.
+	Dir string
+	// Vars are substituted for ${name} placeholders in content, see RenderVars.
+	// Undefined placeholders are errors, also when Vars are nil. Checksum is computed over raw content.
+	Vars map[string]string
+	// Patterns match names of migration files, DefaultPatterns when empty.
+	Patterns []string
+	// NonRecursive skips subdirectories.
+	NonRecursive bool
+	// IDScheme defines how IDs are derived from file paths, IDBaseName by default.
+	// Files getting the same ID are errors.
+	IDScheme IDScheme
+	// StripExtension removes file extension from IDs, like "001_init" for "001_init.sql".
+	StripExtension bool
+	// StreamThreshold is size in bytes, files larger than it are not read to memory,
+	// their migrations have Open func instead of Content, see DatabasePostgres.Migrate.
+	// Streamed migrations do not support includes, they are rendered with Vars line by line.
+	// Zero disables streaming, DefaultStreamThreshold is used by CLI.
+	// Other sources and smaller files still read whole content while listing migrations.
+	StreamThreshold int64
+}
+
+// DefaultStreamThreshold is StreamThreshold suitable for most deployments.
+const DefaultStreamThreshold = 32 << 20
+
+func (sf *SourceFS) Migrations() ([]Migration, error) {
+	paths, err := sf.paths()
 	if err != nil {
-		return nil, fmt.Errorf("could get migration files paths: %s", err)
+		return nil, fmt.Errorf("could get migration files paths: %w", err)
+	}
+
+	relPaths := make([]string, 0, len(paths))
+	for _, p := range paths {
+		relPaths = append(relPaths, sf.relPath(p))
+	}
+	if err := checkIDCollisions(relPaths, sf.IDScheme, sf.StripExtension); err != nil {
+		return nil, err
 	}
 
 	migrations := make([]Migration, 0, len(paths))
-	for _, path := range paths {
-		f, err := os.Open(path)
+	for i, p := range paths {
+		rel := relPaths[i]
+		mig, err := sf.readMigration(p, migrationID(rel, sf.IDScheme, sf.StripExtension))
 		if err != nil {
-			return nil, fmt.Errorf("could not open file %s: %s", path, err)
+			return nil, err
 		}
-		defer f.Close()
+		mig.Repeatable = mig.Repeatable || strings.HasPrefix(path.Base(rel), repeatablePrefix)
+		if mig.Phase == "" {
+			mig.Phase = dirPhase(rel)
+		}
+		mig.Tags = append(mig.Tags, dirTags(rel)...)
 
-		content, err := io.ReadAll(f)
+		if mig.Open == nil {
+			mig, err = renderMigration(mig, sf.Vars)
+			if err != nil {
+				return nil, err
+			}
+		}
+		migrations = append(migrations, mig)
+	}
+
+	return migrations, nil
+}
+
+// readMigration reads migration file, files larger than StreamThreshold are streamed.
+func (sf *SourceFS) readMigration(p, id string) (Migration, error) {
+	if sf.StreamThreshold > 0 {
+		info, err := fs.Stat(sf.FS, p)
 		if err != nil {
-			return nil, fmt.Errorf("could not read contents of file %s: %s", path, err)
+			return Migration{}, fmt.Errorf("could not stat file %s: %w", p, err)
 		}
+		if info.Size() > sf.StreamThreshold {
+			return sf.streamedMigration(p, id)
+		}
+	}
 
-		migrations = append(migrations, Migration{
-			ID:      filepath.Base(path),
-			Content: string(content),
-		})
+	content, err := fs.ReadFile(sf.FS, p)
+	if err != nil {
+		return Migration{}, fmt.Errorf("could not read file %s: %w", p, err)
 	}
 
-	return migrations, nil
+	// directives are parsed from file itself, header of fragments would end it early
+	mig := NewMigration(id, string(content))
+
+	// checksum covers included fragments, so that their changes are detected
+	expanded, err := sf.expandIncludes(p, string(content), nil)
+	if err != nil {
+		return Migration{}, err
+	}
+	mig.Content = expanded
+	mig.Checksum = Checksum(expanded)
+	return mig, nil
 }
 
-func (sr *SourceDir) filepaths() ([]string, error) {
+func (sf *SourceFS) root() string {
+	if sf.Dir == "" {
+		return "."
+	}
+	return sf.Dir
+}
+
+// relPath returns path of file relative to migrations root.
+func (sf *SourceFS) relPath(p string) string {
+	return strings.TrimPrefix(p, sf.root()+"/")
+}
+
+// paths returns paths of migration files, other files not matching Patterns are errors.
+func (sf *SourceFS) paths() ([]string, error) {
+	return sf.walkPaths(true)
+}
+
+// walkPaths returns paths of files matching Patterns, other files
+// are skipped unless strict.
+func (sf *SourceFS) walkPaths(strict bool) ([]string, error) {
+	root := sf.root()
+	patterns := sf.Patterns
+	if len(patterns) == 0 {
+		patterns = DefaultPatterns
+	}
+
+	rules, err := readIgnoreFile(sf.FS, root)
+	if err != nil {
+		return nil, err
+	}
+
 	var paths []string
-	err := filepath.Walk(sr.Dir, func(path string, info fs.FileInfo, err error) error {
+	err = fs.WalkDir(sf.FS, root, func(p string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
-		if info.IsDir() {
+		if p == root {
+			return nil
+		}
+
+		rel := sf.relPath(p)
+		if isHidden(d.Name()) || rules.ignored(rel, d.IsDir()) {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
 			return nil
 		}
-		paths = append(paths, path)
-		return err
+		if d.IsDir() {
+			if sf.NonRecursive {
+				return fs.SkipDir
+			}
+			return nil
+		}
+
+		if strings.HasSuffix(p, downSuffix) {
+			return nil
+		}
+		if isFragment(rel) {
+			return nil
+		}
+		if !matchesAny(patterns, d.Name()) {
+			if !strict {
+				return nil
+			}
+			return fmt.Errorf("unsupported file %s, it does not match %s, add it to %s to skip it", rel, strings.Join(patterns, ", "), ignoreFile)
+		}
+		paths = append(paths, p)
+		return nil
 	})
 	return paths, err
 }
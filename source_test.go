@@ -4,7 +4,9 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"testing/fstest"
 
 	migrations "github.com/ulexxander/go-db-migrations"
 )
@@ -41,7 +43,7 @@ func TestSourceDir(t *testing.T) {
 		t.Fatalf("could not create temp migration files: %s", err)
 	}
 
-	src := migrations.SourceDir{dir}
+	src := migrations.SourceDir{Dir: dir}
 
 	migrations, err := src.Migrations()
 	if err != nil {
@@ -57,6 +59,57 @@ func TestSourceDir(t *testing.T) {
 	migrationEquals(t, migrations[2], "2.migration.sql", migration3)
 }
 
+func TestSourceFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"migrations/1.sql":        {Data: []byte(migration1)},
+		"migrations/2.sql":        {Data: []byte(migration2)},
+		"migrations/2.down.sql":   {Data: []byte("DROP TABLE users;")},
+		"migrations/nested/3.sql": {Data: []byte(migration3)},
+		"other/4.sql":             {Data: []byte("SELECT 1;")},
+	}
+
+	src := migrations.SourceFS{FS: fsys, Dir: "migrations"}
+
+	migrations, err := src.Migrations()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if len(migrations) != 3 {
+		t.Fatalf("expected to get 3 migrations, got: %d", len(migrations))
+	}
+
+	migrationEquals(t, migrations[0], "1.sql", migration1)
+	migrationEquals(t, migrations[1], "2.sql", migration2)
+	migrationEquals(t, migrations[2], "3.sql", migration3)
+}
+
+func TestNewMigration(t *testing.T) {
+	content := "CREATE TABLE users (...);"
+	m := migrations.NewMigration("1.sql", content)
+	if m.Repeatable {
+		t.Errorf("expected migration not to be repeatable")
+	}
+	if m.Checksum != migrations.Checksum(content) {
+		t.Errorf("expected checksum %s, got: %s", migrations.Checksum(content), m.Checksum)
+	}
+
+	m = migrations.NewMigration("R__views.sql", "CREATE OR REPLACE VIEW ...;")
+	if !m.Repeatable {
+		t.Errorf("expected migration with R__ prefix to be repeatable")
+	}
+
+	m = migrations.NewMigration("functions.sql", "-- some comment\n-- migrate:repeatable\n\nCREATE FUNCTION ...;")
+	if !m.Repeatable {
+		t.Errorf("expected migration with repeatable directive to be repeatable")
+	}
+
+	m = migrations.NewMigration("2.sql", "CREATE TABLE t (...);\n-- migrate:repeatable")
+	if m.Repeatable {
+		t.Errorf("expected directive after header to be ignored")
+	}
+}
+
 func migrationEquals(t *testing.T, m migrations.Migration, id, content string) {
 	if m.ID != id {
 		t.Errorf("expected id is %s, got: %s", id, m.ID)
@@ -65,3 +118,77 @@ func migrationEquals(t *testing.T, m migrations.Migration, id, content string) {
 		t.Errorf("expected content is %s, got: %s", content, m.Content)
 	}
 }
+
+func TestSourceFSVars(t *testing.T) {
+	raw := "GRANT SELECT ON users TO ${app_role};\n-- $${kept}\n"
+	fsys := fstest.MapFS{
+		"1_grants.sql": {Data: []byte(raw)},
+	}
+
+	src := migrations.SourceFS{FS: fsys, Vars: map[string]string{"app_role": "app_rw"}}
+	migs, err := src.Migrations()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if migs[0].Content != "GRANT SELECT ON users TO app_rw;\n-- ${kept}\n" {
+		t.Errorf("unexpected rendered content: %q", migs[0].Content)
+	}
+	if migs[0].Checksum != migrations.Checksum(raw) {
+		t.Errorf("expected checksum of raw content")
+	}
+
+	src.Vars = map[string]string{}
+	if _, err := src.Migrations(); err == nil || !strings.Contains(err.Error(), "undefined variables: app_role") {
+		t.Errorf("expected undefined variable error, got: %v", err)
+	}
+
+	// placeholders are errors without vars too
+	src.Vars = nil
+	if _, err := src.Migrations(); err == nil || !strings.Contains(err.Error(), "undefined variables: app_role") {
+		t.Errorf("expected undefined variable error without vars, got: %v", err)
+	}
+
+	fsys["1_grants.sql"] = &fstest.MapFile{Data: []byte("GRANT ${a} TO ${b};\nGRANT ${a} TO ${c};\n-- $${kept}\n")}
+	_, err = src.Migrations()
+	if err == nil || !strings.HasSuffix(err.Error(), "undefined variables: a, b, c") {
+		t.Errorf("expected each undefined variable once, got: %v", err)
+	}
+}
+
+func TestSourceFSFilters(t *testing.T) {
+	fsys := fstest.MapFS{
+		".migrationsignore":    {Data: []byte("# docs\n*.md\ndrafts/\nlegacy/old_*.sql\n")},
+		".DS_Store":            {Data: []byte{}},
+		".1_init.sql.swp":      {Data: []byte{}},
+		"1_init.sql":           {Data: []byte("SELECT 1")},
+		"README.md":            {Data: []byte("docs")},
+		"drafts/2_wip.sql":     {Data: []byte("SELECT 1")},
+		"legacy/old_3.sql":     {Data: []byte("SELECT 1")},
+		"legacy/4_kept.sql":    {Data: []byte("SELECT 1")},
+		"nested/5_nested.psql": {Data: []byte("SELECT 1")},
+	}
+
+	src := migrations.SourceFS{FS: fsys, Patterns: []string{"*.sql", "*.psql"}}
+	migs, err := src.Migrations()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if ids := migrationIDs(migs); ids != "1_init.sql 4_kept.sql 5_nested.psql" {
+		t.Errorf("unexpected migrations: %s", ids)
+	}
+
+	src.NonRecursive = true
+	migs, err = src.Migrations()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if ids := migrationIDs(migs); ids != "1_init.sql" {
+		t.Errorf("unexpected migrations without recursion: %s", ids)
+	}
+
+	src = migrations.SourceFS{FS: fsys}
+	_, err = src.Migrations()
+	if err == nil || !strings.Contains(err.Error(), "unsupported file nested/5_nested.psql") {
+		t.Errorf("expected unsupported file error, got: %v", err)
+	}
+}
@@ -0,0 +1,118 @@
+package migrations
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// Squasher generates single migration with schema snapshot
+// replacing all versioned source migrations up to some ID.
+// Databases that already executed replaced migrations
+// record squashed one as executed without running it.
+type Squasher struct {
+	Logger Logger
+	Source Source
+	// Scratch is empty database where migrations are executed
+	// to take schema snapshot from.
+	Scratch Database
+	// Dump returns schema of scratch database, see DumpPostgres.
+	Dump func() (string, error)
+}
+
+// Squash executes versioned source migrations up to and including toID in scratch database
+// and returns migration with given id containing its schema.
+// Replaced migrations should be removed from source after squashed one is saved.
+func (s *Squasher) Squash(toID, id string) (Migration, error) {
+	migrationsSrc, err := sourceMigrations(s.Source)
+	if err != nil {
+		return Migration{}, err
+	}
+
+	upTo, err := migrationsUpTo(migrationsSrc, toID)
+	if err != nil {
+		return Migration{}, err
+	}
+
+	executed, err := s.Scratch.ExecutedMigrations()
+	if err != nil {
+		return Migration{}, fmt.Errorf("could not get scratch database executed migrations: %s", err)
+	}
+	if len(executed) != 0 {
+		return Migration{}, errors.New("scratch database must be empty")
+	}
+
+	// repeatable migrations stay in source and run after squashed one,
+	// their objects must not be part of the snapshot
+	var versioned []Migration
+	for _, mig := range upTo {
+		if !mig.Repeatable {
+			versioned = append(versioned, mig)
+		}
+	}
+
+	src := SourceDirect(versioned)
+	u := Upgrader{
+		Logger:   s.Logger,
+		Source:   &src,
+		Database: s.Scratch,
+	}
+	if _, err := u.Do(); err != nil {
+		return Migration{}, fmt.Errorf("could not execute migrations in scratch database: %s", err)
+	}
+
+	schema, err := s.Dump()
+	if err != nil {
+		return Migration{}, fmt.Errorf("could not dump scratch database schema: %s", err)
+	}
+
+	var replaced []string
+	for _, mig := range versioned {
+		// squashing already squashed migration replaces its originals as well
+		replaced = append(replaced, mig.Squashes...)
+		replaced = append(replaced, mig.ID)
+	}
+
+	content := fmt.Sprintf("%ssquashes %s\n\n%s", directivePrefix, strings.Join(replaced, " "), schema)
+	return NewMigration(id, content), nil
+}
+
+// DumpPostgres returns schema of postgres database using pg_dump,
+// without migrations tables and session settings.
+func DumpPostgres(dsn string) (string, error) {
+	cmd := exec.Command("pg_dump",
+		"--schema-only",
+		"--no-owner",
+		"--no-privileges",
+		"--exclude-table="+migrationsExecutedTable,
+		"--exclude-table="+migrationsRepeatableTable,
+		dsn,
+	)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("pg_dump failed: %s: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return cleanDump(string(out)), nil
+}
+
+// cleanDump removes lines changing session state,
+// which would leak into migrations executed after the snapshot.
+func cleanDump(dump string) string {
+	var b strings.Builder
+	for _, line := range strings.Split(dump, "\n") {
+		switch {
+		case strings.HasPrefix(line, "SET "),
+			strings.HasPrefix(line, "SELECT pg_catalog.set_config("),
+			// psql meta-commands like \restrict
+			strings.HasPrefix(line, `\`):
+			continue
+		}
+		b.WriteString(line)
+		b.WriteString("\n")
+	}
+	return strings.TrimSpace(b.String()) + "\n"
+}
@@ -0,0 +1,47 @@
+package migrations_test
+
+import (
+	"strings"
+	"testing"
+
+	migrations "github.com/ulexxander/go-db-migrations"
+)
+
+func TestSquasher(t *testing.T) {
+	src := migrations.SourceDirect{
+		{ID: "001.sql", Content: "CREATE TABLE a"},
+		{ID: "R__views.sql", Content: "CREATE VIEW v", Repeatable: true},
+		{ID: "002.sql", Content: "CREATE TABLE b"},
+		{ID: "003.sql", Content: "CREATE TABLE c"},
+	}
+	scratch := DatabaseMock{}
+	s := migrations.Squasher{
+		Source:  &src,
+		Scratch: &scratch,
+		Dump: func() (string, error) {
+			return "CREATE TABLE public.a ();\nCREATE TABLE public.b ();\n", nil
+		},
+	}
+
+	mig, err := s.Squash("002.sql", "002_squashed.sql")
+	if err != nil {
+		t.Fatalf("unexpected squash error: %s", err)
+	}
+
+	if mig.ID != "002_squashed.sql" {
+		t.Errorf("expected id to be 002_squashed.sql, got: %s", mig.ID)
+	}
+	if strings.Join(mig.Squashes, " ") != "001.sql 002.sql" {
+		t.Errorf("expected to squash versioned migrations up to 002.sql, got: %v", mig.Squashes)
+	}
+	if !strings.Contains(mig.Content, "CREATE TABLE public.b ();") {
+		t.Errorf("expected content to contain schema dump, got: %s", mig.Content)
+	}
+	if strings.Join(scratch.migrated, " ") != "001.sql 002.sql" {
+		t.Errorf("expected only versioned migrations up to 002.sql to be executed in scratch database, got: %v", scratch.migrated)
+	}
+
+	if _, err := s.Squash("002.sql", "002_squashed.sql"); err == nil {
+		t.Fatalf("expected to get error when scratch database is not empty, got nil")
+	}
+}
@@ -0,0 +1,158 @@
+package migrations
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Migration states reported by Upgrader.Status.
+const (
+	// StateApplied migration is executed and unchanged.
+	StateApplied = "applied"
+	// StatePending migration will be executed by the next upgrade.
+	StatePending = "pending"
+	// StateMissing migration is executed but not present in source.
+	StateMissing = "missing"
+	// StateModified migration is executed but its content changed since.
+	StateModified = "modified"
+	// StateExcluded migration is not executed and excluded by tags of Upgrader.
+	StateExcluded = "excluded"
+)
+
+// MigrationStatus is the state of single migration
+// merged from source and database.
+type MigrationStatus struct {
+	ID         string `json:"id" yaml:"id"`
+	State      string `json:"state" yaml:"state"`
+	Repeatable bool   `json:"repeatable,omitempty" yaml:"repeatable,omitempty"`
+	// Phase is empty for missing migrations.
+	Phase string `json:"phase,omitempty" yaml:"phase,omitempty"`
+	// Tags are recorded ones for executed migrations, from source otherwise.
+	Tags       []string   `json:"tags,omitempty" yaml:"tags,omitempty"`
+	Baseline   bool       `json:"baseline,omitempty" yaml:"baseline,omitempty"`
+	ExecutedAt *time.Time `json:"executed_at,omitempty" yaml:"executed_at,omitempty"`
+	DurationMS int        `json:"duration_ms,omitempty" yaml:"duration_ms,omitempty"`
+	// Checksum is from source, or from database for missing migrations.
+	Checksum string `json:"checksum,omitempty" yaml:"checksum,omitempty"`
+}
+
+// Status returns state of every source migration in execution order,
+// followed by executed migrations missing from source.
+func (u *Upgrader) Status() ([]MigrationStatus, error) {
+	migrationsSrc, err := sourceMigrations(u.Source)
+	if err != nil {
+		return nil, err
+	}
+
+	executedAlready, err := u.Database.ExecutedMigrations()
+	if err != nil {
+		return nil, fmt.Errorf("could not get already executed migrations: %s", err)
+	}
+
+	repeatableLast, err := u.repeatableMigrations()
+	if err != nil {
+		return nil, fmt.Errorf("could not get executed repeatable migrations: %s", err)
+	}
+
+	executedByID := map[string]Executed{}
+	for _, e := range executedAlready {
+		executedByID[e.ID] = e
+	}
+	repeatableByID := map[string]Executed{}
+	for _, e := range repeatableLast {
+		repeatableByID[e.ID] = e
+	}
+
+	inSource := map[string]struct{}{}
+	statuses := make([]MigrationStatus, 0, len(migrationsSrc))
+	for _, mig := range migrationsSrc {
+		inSource[mig.ID] = struct{}{}
+		for _, id := range mig.Squashes {
+			// replaced by squashed migration, not missing
+			inSource[id] = struct{}{}
+		}
+
+		checksum := mig.Checksum
+		if checksum == "" {
+			checksum = Checksum(mig.Content)
+		}
+
+		status := MigrationStatus{
+			ID:         mig.ID,
+			State:      StatePending,
+			Repeatable: mig.Repeatable,
+			Phase:      mig.phase(),
+			Tags:       mig.Tags,
+			Checksum:   checksum,
+		}
+		if !u.selected(mig) {
+			status.State = StateExcluded
+		}
+
+		executed, ok := executedByID[mig.ID]
+		if mig.Repeatable {
+			executed, ok = repeatableByID[mig.ID]
+		}
+		if ok {
+			status.setExecuted(executed)
+			switch {
+			case executed.Checksum == "" || executed.Checksum == checksum:
+				status.State = StateApplied
+			case mig.Repeatable && !u.selected(mig):
+				status.State = StateExcluded
+			case mig.Repeatable:
+				// will be executed again
+				status.State = StatePending
+			default:
+				status.State = StateModified
+			}
+		}
+
+		statuses = append(statuses, status)
+	}
+
+	var missing []MigrationStatus
+	for _, executed := range append(executedAlready, repeatableLast...) {
+		if _, ok := inSource[executed.ID]; ok {
+			continue
+		}
+		status := MigrationStatus{
+			ID:       executed.ID,
+			State:    StateMissing,
+			Checksum: executed.Checksum,
+		}
+		_, status.Repeatable = repeatableByID[executed.ID]
+		status.setExecuted(executed)
+		missing = append(missing, status)
+	}
+	sort.SliceStable(missing, func(i, j int) bool {
+		return missing[i].ExecutedAt.Before(*missing[j].ExecutedAt)
+	})
+
+	return append(statuses, missing...), nil
+}
+
+func (ms *MigrationStatus) setExecuted(e Executed) {
+	executedAt := e.ExecutedAt
+	ms.ExecutedAt = &executedAt
+	ms.DurationMS = e.DurationMS
+	ms.Baseline = e.Baseline
+	if len(e.Tags) > 0 {
+		ms.Tags = e.Tags
+	}
+}
+
+// FilterStatuses returns statuses having one of given states.
+func FilterStatuses(statuses []MigrationStatus, states ...string) []MigrationStatus {
+	var result []MigrationStatus
+	for _, s := range statuses {
+		for _, state := range states {
+			if s.State == state {
+				result = append(result, s)
+				break
+			}
+		}
+	}
+	return result
+}
@@ -0,0 +1,83 @@
+package migrations_test
+
+import (
+	"testing"
+
+	migrations "github.com/ulexxander/go-db-migrations"
+)
+
+func TestStatus(t *testing.T) {
+	db := DatabaseMock{}
+
+	applied := migrations.Migration{ID: "001.sql", Content: "CREATE TABLE a"}
+	modified := migrations.Migration{ID: "002.sql", Content: "CREATE TABLE b"}
+	pending := migrations.Migration{ID: "003.sql", Content: "CREATE TABLE c"}
+	views := migrations.Migration{ID: "R__views.sql", Content: "CREATE VIEW v", Repeatable: true}
+
+	src := migrations.SourceDirect{applied, modified, views}
+	u := migrations.Upgrader{
+		Source:   &src,
+		Database: &db,
+	}
+	if _, err := u.Do(); err != nil {
+		t.Fatalf("unexpected upgrader error: %s", err)
+	}
+	db.RecordExecution(migrations.Migration{ID: "000_removed.sql"}, 0)
+
+	modified.Content = "CREATE TABLE b (changed)"
+	views.Content = "CREATE OR REPLACE VIEW v"
+	src = migrations.SourceDirect{applied, modified, pending, views}
+
+	statuses, err := u.Status()
+	if err != nil {
+		t.Fatalf("unexpected status error: %s", err)
+	}
+
+	expected := []struct {
+		id    string
+		state string
+	}{
+		{"001.sql", migrations.StateApplied},
+		{"002.sql", migrations.StateModified},
+		{"003.sql", migrations.StatePending},
+		{"R__views.sql", migrations.StatePending},
+		{"000_removed.sql", migrations.StateMissing},
+	}
+	if len(statuses) != len(expected) {
+		t.Fatalf("expected %d statuses, got: %d", len(expected), len(statuses))
+	}
+	for i, e := range expected {
+		s := statuses[i]
+		if s.ID != e.id || s.State != e.state {
+			t.Errorf("expected status %d to be %s %s, got: %s %s", i, e.id, e.state, s.ID, s.State)
+		}
+	}
+
+	if statuses[0].ExecutedAt == nil || statuses[0].DurationMS == 0 {
+		t.Errorf("expected applied migration to have execution time and duration")
+	}
+	if statuses[2].ExecutedAt != nil {
+		t.Errorf("expected pending migration not to have execution time")
+	}
+	if !statuses[3].Repeatable {
+		t.Errorf("expected repeatable migration to be marked repeatable")
+	}
+}
+
+func TestFilterStatuses(t *testing.T) {
+	statuses := []migrations.MigrationStatus{
+		{ID: "1.sql", State: migrations.StateApplied},
+		{ID: "2.sql", State: migrations.StateModified},
+		{ID: "3.sql", State: migrations.StatePending},
+		{ID: "4.sql", State: migrations.StateMissing},
+	}
+
+	drifted := migrations.FilterStatuses(statuses, migrations.StateModified, migrations.StateMissing)
+	if len(drifted) != 2 || drifted[0].ID != "2.sql" || drifted[1].ID != "4.sql" {
+		t.Fatalf("unexpected filtered statuses: %v", drifted)
+	}
+
+	if filtered := migrations.FilterStatuses(statuses); filtered != nil {
+		t.Fatalf("expected no statuses without states, got: %v", filtered)
+	}
+}
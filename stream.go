@@ -0,0 +1,418 @@
+package migrations
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"strings"
+)
+
+// streamedMigration reads migration file larger than SourceFS.StreamThreshold
+// without keeping its content, which is opened again by Migration.Open when executed.
+// Checksum is computed, directives are parsed and variables are checked while reading it once.
+func (sf *SourceFS) streamedMigration(p, id string) (Migration, error) {
+	f, err := sf.FS.Open(p)
+	if err != nil {
+		return Migration{}, fmt.Errorf("could not open file %s: %w", p, err)
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	br := bufio.NewReader(io.TeeReader(f, h))
+
+	// header of comment and blank lines, followed by the rest of lines
+	var header strings.Builder
+	inHeader := true
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return Migration{}, fmt.Errorf("could not read file %s: %w", p, err)
+		}
+		trimmed := strings.TrimSpace(line)
+		if inHeader && trimmed != "" && !strings.HasPrefix(trimmed, "--") {
+			inHeader = false
+		}
+		if inHeader {
+			header.WriteString(line)
+		}
+		if strings.HasPrefix(trimmed, includeDirective+" ") {
+			return Migration{}, fmt.Errorf("include directive is not supported in streamed migration %s", p)
+		}
+		if _, err := RenderVars(line, sf.Vars); err != nil {
+			return Migration{}, fmt.Errorf("migration %s: %w", id, err)
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+
+	mig := NewMigration(id, header.String())
+	mig.Content = ""
+	mig.Checksum = hex.EncodeToString(h.Sum(nil))
+	checksum, vars := mig.Checksum, sf.Vars
+	mig.Open = func() (io.ReadCloser, error) {
+		f, err := sf.FS.Open(p)
+		if err != nil {
+			return nil, err
+		}
+		return newStreamReader(f, id, checksum, vars), nil
+	}
+	return mig, nil
+}
+
+// streamReader reads streamed migration file line by line, rendering lines with vars
+// (see RenderVars), and fails at its end when raw content no longer matches checksum,
+// so that file changed since it was read is not committed.
+type streamReader struct {
+	f        io.Closer
+	br       *bufio.Reader
+	h        hash.Hash
+	id       string
+	checksum string
+	vars     map[string]string
+	pending  []byte
+	err      error
+}
+
+func newStreamReader(f fs.File, id, checksum string, vars map[string]string) *streamReader {
+	h := sha256.New()
+	return &streamReader{
+		f:        f,
+		br:       bufio.NewReader(io.TeeReader(f, h)),
+		h:        h,
+		id:       id,
+		checksum: checksum,
+		vars:     vars,
+	}
+}
+
+func (sr *streamReader) Read(p []byte) (int, error) {
+	for len(sr.pending) == 0 && sr.err == nil {
+		line, err := sr.br.ReadString('\n')
+		if err != nil && err != io.EOF {
+			sr.err = err
+			break
+		}
+		if line, sr.err = RenderVars(line, sr.vars); sr.err != nil {
+			sr.err = fmt.Errorf("migration %s: %w", sr.id, sr.err)
+			break
+		}
+		sr.pending = []byte(line)
+		if err == io.EOF {
+			sr.err = io.EOF
+			if hex.EncodeToString(sr.h.Sum(nil)) != sr.checksum {
+				sr.err = fmt.Errorf("content of migration %s changed since it was read", sr.id)
+			}
+		}
+	}
+
+	n := copy(p, sr.pending)
+	sr.pending = sr.pending[n:]
+	if len(sr.pending) > 0 {
+		return n, nil
+	}
+	return n, sr.err
+}
+
+func (sr *streamReader) Close() error {
+	return sr.f.Close()
+}
+
+// migrateStream executes streamed migration in transaction, see execStream.
+func (dp *DatabasePostgres) migrateStream(tx *sql.Tx, mig Migration) error {
+	r, err := mig.Open()
+	if err != nil {
+		return fmt.Errorf("could not open migration %s: %w", mig.ID, err)
+	}
+	defer r.Close()
+	return execStream(tx, r)
+}
+
+// execStream executes SQL read from r statement by statement, lines are buffered
+// only until statement ends, BEGIN ATOMIC function bodies are kept in their statement. Data of "COPY ... FROM stdin;" statements,
+// in text format terminated by "\." line like in pg_dump output, is loaded row by row.
+func execStream(tx *sql.Tx, r io.Reader) error {
+	br := bufio.NewReader(r)
+	var scanner statementScanner
+	var batch strings.Builder
+	flush := func() error {
+		query := batch.String()
+		batch.Reset()
+		if strings.TrimSpace(query) == "" {
+			return nil
+		}
+		_, err := tx.Exec(query)
+		return err
+	}
+
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("could not read migration: %w", err)
+		}
+		query, isCopy := copyFromStdin(line)
+		switch {
+		case isCopy && scanner.topLevel():
+			if err := flush(); err != nil {
+				return err
+			}
+			if err := copyRows(tx, query, br); err != nil {
+				return err
+			}
+		case scanner.scan(line):
+			batch.WriteString(line)
+			if err := flush(); err != nil {
+				return err
+			}
+		default:
+			batch.WriteString(line)
+		}
+		if err == io.EOF {
+			return flush()
+		}
+	}
+}
+
+// copyFromStdin returns COPY statement without semicolon if line is one,
+// only text format without options is supported.
+func copyFromStdin(line string) (string, bool) {
+	stmt := strings.TrimSpace(line)
+	upper := strings.ToUpper(stmt)
+	if !strings.HasPrefix(upper, "COPY ") || !strings.HasSuffix(upper, " FROM STDIN;") {
+		return "", false
+	}
+	return strings.TrimSuffix(stmt, ";"), true
+}
+
+// copyRows loads rows of COPY data until its terminator.
+func copyRows(tx *sql.Tx, query string, br *bufio.Reader) error {
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		return fmt.Errorf("could not prepare %s: %w", query, err)
+	}
+	defer stmt.Close()
+
+	for rows := 1; ; rows++ {
+		line, err := br.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("could not read migration: %w", err)
+		}
+		line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+		if line == `\.` {
+			break
+		}
+		if err == io.EOF {
+			return errors.New(`COPY data is not terminated with \. line`)
+		}
+
+		fields := strings.Split(line, "\t")
+		values := make([]interface{}, 0, len(fields))
+		for _, field := range fields {
+			values = append(values, decodeCopyField(field))
+		}
+		if _, err := stmt.Exec(values...); err != nil {
+			return fmt.Errorf("could not copy row %d of %s: %w", rows, query, err)
+		}
+	}
+
+	if _, err := stmt.Exec(); err != nil {
+		return fmt.Errorf("could not finish %s: %w", query, err)
+	}
+	return nil
+}
+
+// statementScanner follows quoting of SQL read line by line, to find ends of statements.
+type statementScanner struct {
+	// quote is ' or " inside of string or quoted identifier,
+	// escapes are backslash escapes of E'' string
+	quote   byte
+	escapes bool
+	// dollar is tag of dollar quoted string, like $body$
+	dollar string
+	// comment is depth of nested block comments
+	comment int
+	// atomic is depth of BEGIN ATOMIC body and CASE expressions in it,
+	// word is last word read outside of strings and comments
+	atomic int
+	word   string
+}
+
+// topLevel reports whether scanner is outside of strings and comments.
+func (s *statementScanner) topLevel() bool {
+	return s.quote == 0 && s.dollar == "" && s.comment == 0 && s.atomic == 0
+}
+
+// scan reads line and reports whether it ends with complete statement.
+func (s *statementScanner) scan(line string) bool {
+	ended := false
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		var next byte
+		if i+1 < len(line) {
+			next = line[i+1]
+		}
+		switch {
+		case s.comment > 0:
+			if c == '*' && next == '/' {
+				s.comment--
+				i++
+			} else if c == '/' && next == '*' {
+				s.comment++
+				i++
+			}
+		case s.quote != 0:
+			if s.escapes && c == '\\' {
+				i++
+			} else if c == s.quote && next == s.quote {
+				i++
+			} else if c == s.quote {
+				s.quote = 0
+			}
+		case s.dollar != "":
+			if strings.HasPrefix(line[i:], s.dollar) {
+				i += len(s.dollar) - 1
+				s.dollar = ""
+			}
+		case c == '-' && next == '-':
+			// rest of line is comment
+			return ended
+		case c == '/' && next == '*':
+			s.comment++
+			i++
+		case c == '\'' || c == '"':
+			s.quote = c
+			s.escapes = c == '\'' && i > 0 && (line[i-1] == 'E' || line[i-1] == 'e') && (i < 2 || !isIdentChar(line[i-2]))
+			ended = false
+		case c == '$' && (i == 0 || !isIdentChar(line[i-1])):
+			if tag := dollarTag(line[i:]); tag != "" {
+				s.dollar = tag
+				i += len(tag) - 1
+			}
+			ended = false
+		case isIdentChar(c) && (i == 0 || !isIdentChar(line[i-1])):
+			j := i + 1
+			for j < len(line) && isIdentChar(line[j]) {
+				j++
+			}
+			s.keyword(strings.ToUpper(line[i:j]))
+			i = j - 1
+			ended = false
+		case c == ';':
+			s.word = ""
+			ended = true
+		case c != ' ' && c != '\t' && c != '\r' && c != '\n':
+			s.word = ""
+			ended = false
+		}
+	}
+	return ended && s.topLevel()
+}
+
+// keyword follows BEGIN ATOMIC bodies of SQL functions, like in pg_dump output,
+// which end with END matching it, not with first semicolon.
+func (s *statementScanner) keyword(word string) {
+	switch {
+	case word == "ATOMIC" && s.word == "BEGIN":
+		s.atomic++
+	case word == "CASE" && s.atomic > 0:
+		s.atomic++
+	case word == "END" && s.atomic > 0:
+		s.atomic--
+	}
+	s.word = word
+}
+
+// dollarTag returns opening tag of dollar quoted string s starts with, like $$ or $body$.
+func dollarTag(s string) string {
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+		if c == '$' {
+			return s[:i+1]
+		}
+		if !isIdentChar(c) || (i == 1 && c >= '0' && c <= '9') {
+			return ""
+		}
+	}
+	return ""
+}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+// decodeCopyField decodes field of COPY text format, nil for NULL.
+func decodeCopyField(field string) interface{} {
+	if field == `\N` {
+		return nil
+	}
+	if !strings.Contains(field, `\`) {
+		return field
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(field); i++ {
+		c := field[i]
+		if c != '\\' || i == len(field)-1 {
+			b.WriteByte(c)
+			continue
+		}
+		i++
+		switch c = field[i]; c {
+		case 'b':
+			b.WriteByte('\b')
+		case 'f':
+			b.WriteByte('\f')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 't':
+			b.WriteByte('\t')
+		case 'v':
+			b.WriteByte('\v')
+		case '0', '1', '2', '3', '4', '5', '6', '7':
+			// up to 3 octal digits
+			v := c - '0'
+			for n := 1; n < 3 && i+1 < len(field) && field[i+1] >= '0' && field[i+1] <= '7'; n++ {
+				i++
+				v = v*8 + field[i] - '0'
+			}
+			b.WriteByte(v)
+		case 'x':
+			// up to 2 hex digits
+			v, n := byte(0), 0
+			for ; n < 2 && i+1 < len(field) && isHex(field[i+1]); n++ {
+				i++
+				v = v*16 + hexValue(field[i])
+			}
+			if n == 0 {
+				b.WriteByte('x')
+			} else {
+				b.WriteByte(v)
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+func isHex(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F'
+}
+
+func hexValue(c byte) byte {
+	switch {
+	case c >= 'a':
+		return c - 'a' + 10
+	case c >= 'A':
+		return c - 'A' + 10
+	}
+	return c - '0'
+}
@@ -0,0 +1,424 @@
+package migrations_test
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	migrations "github.com/ulexxander/go-db-migrations"
+)
+
+const seedContent = `-- migrate:tags seed
+CREATE TABLE first (id int, name text);
+COPY first (id, name) FROM stdin;
+1	alice
+2	\N
+3	tab\there\\
+\.
+UPDATE first SET name = 'bob' WHERE id = 2;
+INSERT INTO first VALUES (4, 'semi;
+colon');
+DO $$
+BEGIN
+	INSERT INTO first VALUES (5, 'do');
+END;
+$$;
+`
+
+func TestSourceFSStreamThreshold(t *testing.T) {
+	fsys := fstest.MapFS{
+		"001_small.sql": {Data: []byte("SELECT 1;")},
+		"002_seed.sql":  {Data: []byte(seedContent)},
+	}
+	src := migrations.SourceFS{FS: fsys, StreamThreshold: 100}
+
+	migs, err := src.Migrations()
+	if err != nil {
+		t.Fatalf("could not read migrations: %s", err)
+	}
+	if len(migs) != 2 {
+		t.Fatalf("expected 2 migrations, got: %d", len(migs))
+	}
+
+	small := migs[0]
+	if small.Open != nil || small.Content != "SELECT 1;" {
+		t.Errorf("expected small migration read to memory: %+v", small)
+	}
+
+	seed := migs[1]
+	if seed.Open == nil || seed.Content != "" {
+		t.Fatalf("expected streamed migration without content: %+v", seed)
+	}
+	if seed.Checksum != migrations.Checksum(seedContent) {
+		t.Errorf("expected checksum of whole content, got: %s", seed.Checksum)
+	}
+	if len(seed.Tags) != 1 || seed.Tags[0] != "seed" {
+		t.Errorf("expected tags parsed from header, got: %v", seed.Tags)
+	}
+
+	r, err := seed.Open()
+	if err != nil {
+		t.Fatalf("could not open streamed migration: %s", err)
+	}
+	defer r.Close()
+	content, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("could not read streamed migration: %s", err)
+	}
+	if string(content) != seedContent {
+		t.Errorf("unexpected streamed content: %s", content)
+	}
+
+	fsys["003_include.sql"] = &fstest.MapFile{Data: []byte(strings.Repeat("SELECT 1;\n", 20) + "-- migrate:include _x.sql\n")}
+	if _, err := src.Migrations(); err == nil || !strings.Contains(err.Error(), "include directive is not supported") {
+		t.Errorf("expected include error, got: %v", err)
+	}
+}
+
+// openCounter counts files of FS which are open.
+type openCounter struct {
+	fs.FS
+	open int
+}
+
+type countedFile struct {
+	fs.File
+	c *openCounter
+}
+
+func (f *countedFile) Close() error {
+	f.c.open--
+	return f.File.Close()
+}
+
+type countedDir struct {
+	fs.ReadDirFile
+	c *openCounter
+}
+
+func (d *countedDir) Close() error {
+	d.c.open--
+	return d.ReadDirFile.Close()
+}
+
+func (c *openCounter) Open(name string) (fs.File, error) {
+	f, err := c.FS.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	c.open++
+	if d, ok := f.(fs.ReadDirFile); ok {
+		return &countedDir{d, c}, nil
+	}
+	return &countedFile{f, c}, nil
+}
+
+func TestSourceFSClosesFiles(t *testing.T) {
+	counter := &openCounter{FS: fstest.MapFS{
+		"001_small.sql":      {Data: []byte("SELECT 1;")},
+		"002_seed.sql":       {Data: []byte(seedContent)},
+		"nested/003_big.sql": {Data: []byte(seedContent)},
+	}}
+	src := migrations.SourceFS{FS: counter, StreamThreshold: 100}
+
+	migs, err := src.Migrations()
+	if err != nil {
+		t.Fatalf("could not read migrations: %s", err)
+	}
+	if counter.open != 0 {
+		t.Fatalf("expected all files closed after reading migrations, %d are open", counter.open)
+	}
+
+	for _, mig := range migs[1:] {
+		r, err := mig.Open()
+		if err != nil {
+			t.Fatalf("could not open streamed migration: %s", err)
+		}
+		if counter.open != 1 {
+			t.Errorf("expected only streamed file open, %d are open", counter.open)
+		}
+		if _, err := io.ReadAll(r); err != nil {
+			t.Fatalf("could not read streamed migration: %s", err)
+		}
+		r.Close()
+	}
+	if counter.open != 0 {
+		t.Errorf("expected streamed files closed, %d are open", counter.open)
+	}
+}
+
+func TestSourceFSStreamVars(t *testing.T) {
+	content := "-- seed\nGRANT SELECT ON first TO ${app_role};\nSELECT '$${kept}';\n"
+	fsys := fstest.MapFS{"001_seed.sql": {Data: []byte(content)}}
+	src := migrations.SourceFS{FS: fsys, StreamThreshold: 1, Vars: map[string]string{"app_role": "app_rw"}}
+
+	migs, err := src.Migrations()
+	if err != nil {
+		t.Fatalf("could not read migrations: %s", err)
+	}
+	if migs[0].Checksum != migrations.Checksum(content) {
+		t.Errorf("expected checksum of raw content")
+	}
+
+	r, err := migs[0].Open()
+	if err != nil {
+		t.Fatalf("could not open streamed migration: %s", err)
+	}
+	rendered, err := io.ReadAll(r)
+	r.Close()
+	if err != nil {
+		t.Fatalf("could not read streamed migration: %s", err)
+	}
+	if string(rendered) != "-- seed\nGRANT SELECT ON first TO app_rw;\nSELECT '${kept}';\n" {
+		t.Errorf("unexpected rendered content: %q", rendered)
+	}
+
+	// file changed since it was read fails at its end
+	fsys["001_seed.sql"].Data = []byte(strings.Replace(content, "first", "second", 1))
+	r, err = migs[0].Open()
+	if err != nil {
+		t.Fatalf("could not open streamed migration: %s", err)
+	}
+	_, err = io.ReadAll(r)
+	r.Close()
+	if err == nil || !strings.Contains(err.Error(), "changed since it was read") {
+		t.Errorf("expected changed content error, got: %v", err)
+	}
+
+	src.Vars = map[string]string{}
+	if _, err := src.Migrations(); err == nil || !strings.Contains(err.Error(), "undefined variables: app_role") {
+		t.Errorf("expected undefined variable error, got: %v", err)
+	}
+}
+
+func TestMigrateStreamsCopyData(t *testing.T) {
+	db, err := openDB()
+	if err != nil {
+		t.Fatalf("could not setup db: %s", err)
+	}
+	defer db.Close()
+	if err := resetDB(db); err != nil {
+		t.Fatalf("could not reset db: %s", err)
+	}
+
+	fsys := fstest.MapFS{"001_seed.sql": {Data: []byte(seedContent)}}
+	migs, err := (&migrations.SourceFS{FS: fsys, StreamThreshold: 1}).Migrations()
+	if err != nil {
+		t.Fatalf("could not read migrations: %s", err)
+	}
+
+	dbp := migrations.DatabasePostgres{DB: db}
+	if err := dbp.Migrate(migs[0]); err != nil {
+		t.Fatalf("could not execute streamed migration: %s", err)
+	}
+
+	rows, err := db.Query("SELECT id, name FROM first ORDER BY id")
+	if err != nil {
+		t.Fatalf("could not query rows: %s", err)
+	}
+	defer rows.Close()
+	var names []string
+	for rows.Next() {
+		var id int
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			t.Fatalf("could not scan row: %s", err)
+		}
+		names = append(names, name)
+	}
+	if strings.Join(names, "|") != "alice|bob|tab\there\\|semi;\ncolon|do" {
+		t.Errorf("unexpected rows: %q", names)
+	}
+
+	// files with CRLF line endings
+	crlf := strings.Join([]string{"CREATE TABLE third (id int);", "COPY third (id) FROM stdin;", "1", "2", `\.`, ""}, "\r\n")
+	fsys["002_crlf.sql"] = &fstest.MapFile{Data: []byte(crlf)}
+	migs, err = (&migrations.SourceFS{FS: fsys, StreamThreshold: 1}).Migrations()
+	if err != nil {
+		t.Fatalf("could not read migrations: %s", err)
+	}
+	if err := dbp.Migrate(migs[1]); err != nil {
+		t.Fatalf("could not execute streamed migration with CRLF: %s", err)
+	}
+	var count int
+	if err := db.QueryRow("SELECT count(*) FROM third").Scan(&count); err != nil || count != 2 {
+		t.Errorf("expected 2 copied rows, got: %d, %v", count, err)
+	}
+
+	// content changed after it was read is not committed
+	fsys["001_seed.sql"].Data = []byte(strings.Replace(seedContent, "first", "second", -1))
+	if err := dbp.Migrate(migs[0]); err == nil || !strings.Contains(err.Error(), "changed since it was read") {
+		t.Errorf("expected changed content error, got: %v", err)
+	}
+	var exists bool
+	if err := db.QueryRow("SELECT to_regclass('second') IS NOT NULL").Scan(&exists); err != nil {
+		t.Fatalf("could not check table: %s", err)
+	}
+	if exists {
+		t.Errorf("expected changed migration rolled back")
+	}
+}
+
+// recordingConnector records statements executed in transactions, without database.
+type recordingConnector struct {
+	execs *[]string
+}
+
+func (rc recordingConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	return &recordingConn{execs: rc.execs}, nil
+}
+
+func (recordingConnector) Driver() driver.Driver {
+	return nil
+}
+
+type recordingConn struct {
+	execs *[]string
+	inTx  bool
+}
+
+func (rc *recordingConn) Prepare(query string) (driver.Stmt, error) {
+	return &recordingStmt{conn: rc, query: query}, nil
+}
+
+func (rc *recordingConn) Close() error {
+	return nil
+}
+
+func (rc *recordingConn) Begin() (driver.Tx, error) {
+	rc.inTx = true
+	return rc, nil
+}
+
+func (rc *recordingConn) Commit() error {
+	rc.inTx = false
+	*rc.execs = append(*rc.execs, "COMMIT")
+	return nil
+}
+
+func (rc *recordingConn) Rollback() error {
+	rc.inTx = false
+	*rc.execs = append(*rc.execs, "ROLLBACK")
+	return nil
+}
+
+type recordingStmt struct {
+	conn  *recordingConn
+	query string
+}
+
+func (rs *recordingStmt) Close() error {
+	return nil
+}
+
+func (rs *recordingStmt) NumInput() int {
+	return -1
+}
+
+func (rs *recordingStmt) Exec(args []driver.Value) (driver.Result, error) {
+	if rs.conn.inTx {
+		exec := strings.TrimSpace(rs.query)
+		if len(args) > 0 {
+			exec += " " + fmt.Sprint(args)
+		}
+		*rs.conn.execs = append(*rs.conn.execs, exec)
+	}
+	return driver.RowsAffected(0), nil
+}
+
+func (rs *recordingStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return nil, errors.New("not implemented")
+}
+
+func TestMigrateStreamSplitsStatements(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		execs   []string
+		err     string
+	}{
+		{
+			name:    "statements",
+			content: "SELECT 1;\nSELECT 2; \n\nSELECT\n  3;\nSELECT 4",
+			execs:   []string{"SELECT 1;", "SELECT 2;", "SELECT\n  3;", "SELECT 4"},
+		},
+		{
+			name:    "quotes",
+			content: "INSERT INTO t VALUES ('a;b', 'it''s;');\nSELECT 1 AS \"x;\"\"y\";\nINSERT INTO t VALUES ('semi;\ncolon');\n",
+			execs:   []string{"INSERT INTO t VALUES ('a;b', 'it''s;');", "SELECT 1 AS \"x;\"\"y\";", "INSERT INTO t VALUES ('semi;\ncolon');"},
+		},
+		{
+			name:    "escape strings",
+			content: "SELECT E'it\\'s;', e'\\\\';\nSELECT 'a\\';\nSELECT 'E\\';\n",
+			execs:   []string{"SELECT E'it\\'s;', e'\\\\';", "SELECT 'a\\';", "SELECT 'E\\';"},
+		},
+		{
+			name:    "dollar quoting",
+			content: "DO $$\nBEGIN\n\tPERFORM 1;\nEND;\n$$;\nCREATE FUNCTION f() RETURNS text AS $body$ SELECT '$$;' $body$ LANGUAGE sql;\nPREPARE p AS SELECT $1;\n",
+			execs:   []string{"DO $$\nBEGIN\n\tPERFORM 1;\nEND;\n$$;", "CREATE FUNCTION f() RETURNS text AS $body$ SELECT '$$;' $body$ LANGUAGE sql;", "PREPARE p AS SELECT $1;"},
+		},
+		{
+			name:    "comments",
+			content: "SELECT 1; -- trailing;\n-- only comment;\nSELECT 2;\n/* outer /* inner; */ still; */ SELECT 3;\n/* multi;\nline */ SELECT 4;\n",
+			execs:   []string{"SELECT 1; -- trailing;", "-- only comment;\nSELECT 2;", "/* outer /* inner; */ still; */ SELECT 3;", "/* multi;\nline */ SELECT 4;"},
+		},
+		{
+			name:    "begin atomic",
+			content: "CREATE FUNCTION f() RETURNS int\n    LANGUAGE sql\n    BEGIN ATOMIC\n SELECT CASE WHEN true THEN 1 END;\n SELECT 2;\nEND;\nSELECT atomic FROM t;\nBEGIN;\nSELECT 3;\n",
+			execs:   []string{"CREATE FUNCTION f() RETURNS int\n    LANGUAGE sql\n    BEGIN ATOMIC\n SELECT CASE WHEN true THEN 1 END;\n SELECT 2;\nEND;", "SELECT atomic FROM t;", "BEGIN;", "SELECT 3;"},
+		},
+		{
+			name:    "copy",
+			content: "CREATE TABLE t (a int, b text);\ncopy t (a, b) FROM stdin;\n1\tx\n2\t\\N\n3\ttab\\there\n\\.\nSELECT 1;\n",
+			execs:   []string{"CREATE TABLE t (a int, b text);", "copy t (a, b) FROM stdin [1 x]", "copy t (a, b) FROM stdin [2 <nil>]", "copy t (a, b) FROM stdin [3 tab\there]", "copy t (a, b) FROM stdin", "SELECT 1;"},
+		},
+		{
+			name:    "copy in string",
+			content: "SELECT '\nCOPY t FROM stdin;\n';\n",
+			execs:   []string{"SELECT '\nCOPY t FROM stdin;\n';"},
+		},
+		{
+			name:    "copy not terminated",
+			content: "COPY t FROM stdin;\n1\n",
+			execs:   []string{"COPY t FROM stdin [1]"},
+			err:     "not terminated",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var execs []string
+			db := sql.OpenDB(recordingConnector{execs: &execs})
+			defer db.Close()
+
+			content := tt.content
+			mig := migrations.Migration{
+				ID: "001.sql",
+				Open: func() (io.ReadCloser, error) {
+					return io.NopCloser(strings.NewReader(content)), nil
+				},
+			}
+			err := (&migrations.DatabasePostgres{DB: db}).Migrate(mig)
+			want := append(tt.execs, "COMMIT")
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected error containing %q, got: %v", tt.err, err)
+				}
+				want = append(tt.execs, "ROLLBACK")
+			} else if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if strings.Join(execs, "\n---\n") != strings.Join(want, "\n---\n") {
+				t.Errorf("expected statements:\n%q\ngot:\n%q", want, execs)
+			}
+		})
+	}
+}
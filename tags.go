@@ -0,0 +1,50 @@
+package migrations
+
+import (
+	"path"
+	"strings"
+)
+
+// tagDirPrefix marks directory whose migrations are tagged by its name,
+// for example "@seed/001_users.sql" has tag "seed".
+const tagDirPrefix = "@"
+
+// dirTags returns tags of file from its directories.
+func dirTags(relPath string) []string {
+	var tags []string
+	for _, dir := range strings.Split(path.Dir(relPath), "/") {
+		if strings.HasPrefix(dir, tagDirPrefix) && len(dir) > len(tagDirPrefix) {
+			tags = append(tags, strings.TrimPrefix(dir, tagDirPrefix))
+		}
+	}
+	return tags
+}
+
+// MatchTags reports whether migration with given tags is selected by filters.
+// Untagged migrations are always selected. Tagged ones are selected when they have
+// any of include tags (or include is empty) and none of exclude tags.
+func MatchTags(tags, include, exclude []string) bool {
+	if len(tags) == 0 {
+		return true
+	}
+	if hasAnyTag(tags, exclude) {
+		return false
+	}
+	return len(include) == 0 || hasAnyTag(tags, include)
+}
+
+func hasAnyTag(tags, of []string) bool {
+	for _, tag := range tags {
+		for _, t := range of {
+			if tag == t {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// selected reports whether migration is selected by tag filters of Upgrader.
+func (u *Upgrader) selected(mig Migration) bool {
+	return MatchTags(mig.Tags, u.IncludeTags, u.ExcludeTags)
+}
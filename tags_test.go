@@ -0,0 +1,133 @@
+package migrations_test
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	migrations "github.com/ulexxander/go-db-migrations"
+)
+
+func TestMatchTags(t *testing.T) {
+	tests := []struct {
+		tags, include, exclude []string
+		match                  bool
+	}{
+		{tags: nil, include: []string{"dev"}, match: true},
+		{tags: []string{"seed"}, match: true},
+		{tags: []string{"seed"}, include: []string{"dev"}, match: false},
+		{tags: []string{"seed", "dev"}, include: []string{"dev"}, match: true},
+		{tags: []string{"seed", "dev"}, include: []string{"dev"}, exclude: []string{"seed"}, match: false},
+		{tags: []string{"prod"}, exclude: []string{"dev"}, match: true},
+	}
+	for _, tt := range tests {
+		if match := migrations.MatchTags(tt.tags, tt.include, tt.exclude); match != tt.match {
+			t.Errorf("tags %v with include %v and exclude %v: expected %t, got %t", tt.tags, tt.include, tt.exclude, tt.match, match)
+		}
+	}
+}
+
+func TestUpgraderTags(t *testing.T) {
+	db := DatabaseMock{}
+	src := migrations.SourceDirect{
+		migrations.NewMigration("1_schema.sql", "SELECT 1"),
+		migrations.NewMigration("2_fixtures.sql", "-- migrate:tags test, dev\nSELECT 1"),
+		migrations.NewMigration("3_grants.sql", "-- migrate:tags prod\nSELECT 1"),
+	}
+	u := migrations.Upgrader{
+		Source:      &src,
+		Database:    &db,
+		IncludeTags: []string{"dev"},
+	}
+
+	result, err := u.Do()
+	if err != nil {
+		t.Fatalf("unexpected upgrader error: %s", err)
+	}
+	resultEquals(t, result, []migrations.Migration{src[0], src[1]})
+
+	statuses, err := u.Status()
+	if err != nil {
+		t.Fatalf("could not get status: %s", err)
+	}
+	expected := []struct{ state, tags string }{
+		{migrations.StateApplied, ""},
+		{migrations.StateApplied, "test,dev"},
+		{migrations.StateExcluded, "prod"},
+	}
+	for i, e := range expected {
+		s := statuses[i]
+		if s.State != e.state || strings.Join(s.Tags, ",") != e.tags {
+			t.Errorf("unexpected status of %s: %s with tags %v", s.ID, s.State, s.Tags)
+		}
+	}
+
+	u.IncludeTags = nil
+	u.ExcludeTags = []string{"dev"}
+	result, err = u.Do()
+	if err != nil {
+		t.Fatalf("unexpected upgrader error: %s", err)
+	}
+	resultEquals(t, result, []migrations.Migration{src[2]})
+}
+
+func TestUpgraderTagsExcludedDependency(t *testing.T) {
+	db := DatabaseMock{}
+	src := migrations.SourceDirect{
+		migrations.NewMigration("1_fixtures.sql", "-- migrate:tags dev\nSELECT 1"),
+		migrations.NewMigration("2_reports.sql", "-- migrate:depends 1_fixtures.sql\nSELECT 1"),
+	}
+	u := migrations.Upgrader{
+		Source:      &src,
+		Database:    &db,
+		ExcludeTags: []string{"dev"},
+	}
+
+	_, err := u.Do()
+	if err == nil || !strings.Contains(err.Error(), "depends on 1_fixtures.sql, which is excluded by tags") {
+		t.Fatalf("expected error of dependency excluded by tags, got: %v", err)
+	}
+	if len(db.executed) != 0 {
+		t.Errorf("expected nothing to be executed, got: %d", len(db.executed))
+	}
+
+	// dependency executed before is fine
+	u.ExcludeTags = nil
+	u.IncludeTags = []string{"dev"}
+	if _, err := u.Do(); err != nil {
+		t.Fatalf("unexpected upgrader error: %s", err)
+	}
+	u.IncludeTags = nil
+	u.ExcludeTags = []string{"dev"}
+	if _, err := u.Do(); err != nil {
+		t.Errorf("unexpected error with executed dependency: %s", err)
+	}
+}
+
+func TestSourceFSTagDirectories(t *testing.T) {
+	fsys := fstest.MapFS{
+		"001_init.sql":            {Data: []byte("SELECT 1")},
+		"@seed/002_users.sql":     {Data: []byte("-- migrate:tags dev\nSELECT 1")},
+		"@seed/@demo/003_org.sql": {Data: []byte("SELECT 1")},
+	}
+	src := migrations.SourceFS{FS: fsys}
+
+	migs, err := src.Migrations()
+	if err != nil {
+		t.Fatalf("could not read migrations: %s", err)
+	}
+	tags := map[string]string{}
+	for _, mig := range migs {
+		tags[mig.ID] = strings.Join(mig.Tags, ",")
+	}
+	expected := map[string]string{
+		"001_init.sql":  "",
+		"002_users.sql": "dev,seed",
+		"003_org.sql":   "seed,demo",
+	}
+	for id, tag := range expected {
+		if tags[id] != tag {
+			t.Errorf("expected %s to have tags %q, got: %q", id, tag, tags[id])
+		}
+	}
+}
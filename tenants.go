@@ -0,0 +1,199 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrSkipped is error of targets that were not migrated
+// because migrations of another target failed or context was canceled.
+var ErrSkipped = errors.New("skipped")
+
+// TargetResult is outcome of migrating one target of fan-out, such as tenant schema.
+type TargetResult struct {
+	Target string
+	// Result can be nil when Err is set.
+	Result   *UpgradeResult
+	Err      error
+	Duration time.Duration
+}
+
+// FanOutSummary counts targets by outcome.
+type FanOutSummary struct {
+	Succeeded int
+	Failed    int
+	Skipped   int
+}
+
+// Summarize counts results by outcome.
+func Summarize(results []TargetResult) FanOutSummary {
+	var s FanOutSummary
+	for _, r := range results {
+		switch {
+		case r.Err == nil:
+			s.Succeeded++
+		case errors.Is(r.Err, ErrSkipped):
+			s.Skipped++
+		default:
+			s.Failed++
+		}
+	}
+	return s
+}
+
+// FanOut calls fn for each target, at most concurrency at once.
+// Unless continueOnError is set, targets not started yet after failure are skipped.
+// Results are in order of targets.
+func FanOut(ctx context.Context, targets []string, concurrency int, continueOnError bool, fn func(ctx context.Context, target string) (*UpgradeResult, error)) []TargetResult {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	results := make([]TargetResult, len(targets))
+	for i, target := range targets {
+		results[i] = TargetResult{Target: target, Err: ErrSkipped}
+	}
+
+	var (
+		mu     sync.Mutex
+		failed bool
+		wg     sync.WaitGroup
+	)
+	sem := make(chan struct{}, concurrency)
+
+	for i := range targets {
+		sem <- struct{}{}
+
+		mu.Lock()
+		stop := failed && !continueOnError
+		mu.Unlock()
+		if stop || ctx.Err() != nil {
+			<-sem
+			break
+		}
+
+		wg.Add(1)
+		go func(i int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			start := time.Now()
+			result, err := fn(ctx, targets[i])
+
+			mu.Lock()
+			results[i].Result = result
+			results[i].Err = err
+			results[i].Duration = time.Since(start)
+			if err != nil {
+				failed = true
+			}
+			mu.Unlock()
+		}(i)
+	}
+
+	wg.Wait()
+	return results
+}
+
+// logResults logs outcome of each target of kind, like "schema",
+// and returns error when migrations failed in any target or any was skipped.
+func logResults(logger Logger, kind string, results []TargetResult) error {
+	name := strings.ToUpper(kind[:1]) + kind[1:]
+	for _, r := range results {
+		switch {
+		case r.Err == nil:
+			logger.Info(name+" migrated", kind, r.Target, "executed", len(r.Result.Executed), "duration_ms", r.Duration.Milliseconds())
+		case errors.Is(r.Err, ErrSkipped):
+			logger.Warn(name+" skipped", kind, r.Target)
+		default:
+			logger.Error(name+" migration failed", kind, r.Target, "error", r.Err)
+		}
+	}
+
+	summary := Summarize(results)
+	if summary.Failed > 0 || summary.Skipped > 0 {
+		return fmt.Errorf("migrations failed in %d of %d %ss, %d skipped", summary.Failed, len(results), kind, summary.Skipped)
+	}
+	return nil
+}
+
+// TenantsRunner applies the same migrations to many postgres schemas,
+// each one having its own migrations tables, see DatabasePostgres.Schema.
+type TenantsRunner struct {
+	DB     *sql.DB
+	Source Source
+	Logger Logger
+	// Schemas to migrate.
+	Schemas []string
+	// SchemasQuery selects names of schemas to migrate,
+	// used when Schemas are empty.
+	SchemasQuery string
+	// Concurrency is max number of schemas migrated at once, defaults to 1.
+	Concurrency int
+	// ContinueOnError keeps migrating other schemas after failure.
+	ContinueOnError bool
+	// Phase, IncludeTags and ExcludeTags select migrations, see Upgrader.
+	Phase       string
+	IncludeTags []string
+	ExcludeTags []string
+}
+
+// Run migrates all schemas and returns result of each one.
+// Error is returned when schemas can not be listed or migrations failed in any of them.
+func (tr *TenantsRunner) Run(ctx context.Context) ([]TargetResult, error) {
+	logger := tr.Logger
+	if logger == nil {
+		logger = nopLogger{}
+	}
+
+	schemas, err := tr.schemas(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	results := FanOut(ctx, schemas, tr.Concurrency, tr.ContinueOnError, func(ctx context.Context, schema string) (*UpgradeResult, error) {
+		u := Upgrader{
+			Logger:      &fieldsLogger{Logger: logger, keyvals: []interface{}{"schema", schema}},
+			Source:      tr.Source,
+			Database:    &DatabasePostgres{DB: tr.DB, Schema: schema},
+			Phase:       tr.Phase,
+			IncludeTags: tr.IncludeTags,
+			ExcludeTags: tr.ExcludeTags,
+		}
+		return u.Do()
+	})
+
+	return results, logResults(logger, "schema", results)
+}
+
+func (tr *TenantsRunner) schemas(ctx context.Context) ([]string, error) {
+	if len(tr.Schemas) > 0 || tr.SchemasQuery == "" {
+		return tr.Schemas, nil
+	}
+
+	rows, err := tr.DB.QueryContext(ctx, tr.SchemasQuery)
+	if err != nil {
+		return nil, fmt.Errorf("could not select schemas: %w", err)
+	}
+	defer rows.Close()
+
+	var schemas []string
+	for rows.Next() {
+		var schema string
+		if err := rows.Scan(&schema); err != nil {
+			return nil, fmt.Errorf("could not scan schema: %w", err)
+		}
+		schemas = append(schemas, schema)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not select schemas: %w", err)
+	}
+	return schemas, nil
+}
@@ -0,0 +1,193 @@
+package migrations_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	migrations "github.com/ulexxander/go-db-migrations"
+)
+
+func TestFanOut(t *testing.T) {
+	targets := []string{"a", "b", "c", "d", "e", "f"}
+
+	var mu sync.Mutex
+	running, maxRunning := 0, 0
+	results := migrations.FanOut(context.Background(), targets, 2, false, func(ctx context.Context, target string) (*migrations.UpgradeResult, error) {
+		mu.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mu.Unlock()
+
+		defer func() {
+			mu.Lock()
+			running--
+			mu.Unlock()
+		}()
+		return &migrations.UpgradeResult{}, nil
+	})
+
+	if maxRunning > 2 {
+		t.Errorf("expected at most 2 targets at once, got: %d", maxRunning)
+	}
+	if len(results) != len(targets) {
+		t.Fatalf("expected %d results, got: %d", len(targets), len(results))
+	}
+	for i, r := range results {
+		if r.Target != targets[i] {
+			t.Errorf("expected result %d to be of target %s, got: %s", i, targets[i], r.Target)
+		}
+		if r.Err != nil {
+			t.Errorf("unexpected error of target %s: %s", r.Target, r.Err)
+		}
+	}
+}
+
+func TestFanOutStopsOnError(t *testing.T) {
+	targets := []string{"a", "b", "c"}
+	fail := func(ctx context.Context, target string) (*migrations.UpgradeResult, error) {
+		if target == "a" {
+			return nil, fmt.Errorf("broken")
+		}
+		return &migrations.UpgradeResult{}, nil
+	}
+
+	results := migrations.FanOut(context.Background(), targets, 1, false, fail)
+	summary := migrations.Summarize(results)
+	if summary != (migrations.FanOutSummary{Failed: 1, Skipped: 2}) {
+		t.Errorf("unexpected summary: %+v", summary)
+	}
+	if !errors.Is(results[1].Err, migrations.ErrSkipped) {
+		t.Errorf("expected target b to be skipped, got: %v", results[1].Err)
+	}
+
+	results = migrations.FanOut(context.Background(), targets, 1, true, fail)
+	summary = migrations.Summarize(results)
+	if summary != (migrations.FanOutSummary{Succeeded: 2, Failed: 1}) {
+		t.Errorf("unexpected summary with continue on error: %+v", summary)
+	}
+}
+
+var tenantSchemas = []string{"tenant_a", "tenant_b"}
+
+func resetTenants(t *testing.T) *migrations.TenantsRunner {
+	db, err := openDB()
+	if err != nil {
+		t.Fatalf("could not setup db: %s", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	for _, schema := range tenantSchemas {
+		if _, err := db.Exec("DROP SCHEMA IF EXISTS " + schema + " CASCADE"); err != nil {
+			t.Fatalf("could not drop schema %s: %s", schema, err)
+		}
+	}
+
+	return &migrations.TenantsRunner{
+		DB: db,
+		Source: migrations.SourceDirect{
+			migrations.NewMigration("1_first.sql", "CREATE TABLE first (id int)"),
+		},
+		Schemas:     tenantSchemas,
+		Concurrency: 2,
+	}
+}
+
+func TestTenantsRunner(t *testing.T) {
+	tr := resetTenants(t)
+
+	results, err := tr.Run(context.Background())
+	if err != nil {
+		t.Fatalf("could not migrate tenants: %s", err)
+	}
+
+	for i, r := range results {
+		if r.Target != tenantSchemas[i] || len(r.Result.Executed) != 1 {
+			t.Errorf("unexpected result of %s: %+v", tenantSchemas[i], r)
+		}
+
+		var tables int
+		err := tr.DB.QueryRow(
+			"SELECT count(*) FROM information_schema.tables WHERE table_schema = $1 AND table_name IN ($2, $3)",
+			r.Target, table1, migrationsExecutedTable,
+		).Scan(&tables)
+		if err != nil {
+			t.Fatalf("could not count tables: %s", err)
+		}
+		if tables != 2 {
+			t.Errorf("expected migration and history tables in schema %s, got %d tables", r.Target, tables)
+		}
+	}
+
+	results, err = tr.Run(context.Background())
+	if err != nil {
+		t.Fatalf("could not migrate tenants again: %s", err)
+	}
+	for _, r := range results {
+		if len(r.Result.Executed) != 0 {
+			t.Errorf("expected nothing to execute in %s, got: %d", r.Target, len(r.Result.Executed))
+		}
+	}
+}
+
+func TestTenantsRunnerSelectsMigrations(t *testing.T) {
+	tr := resetTenants(t)
+	tr.Source = migrations.SourceDirect{
+		migrations.NewMigration("1_first.sql", "CREATE TABLE first (id int)"),
+		migrations.NewMigration("2_fixtures.sql", "-- migrate:tags test\nCREATE TABLE fixtures (id int)"),
+		migrations.NewMigration("3_cleanup.sql", "-- migrate:phase post\nCREATE TABLE cleanup (id int)"),
+	}
+	tr.Phase = migrations.PhasePre
+	tr.ExcludeTags = []string{"test"}
+
+	results, err := tr.Run(context.Background())
+	if err != nil {
+		t.Fatalf("could not migrate tenants: %s", err)
+	}
+	for _, r := range results {
+		if len(r.Result.Executed) != 1 || r.Result.Executed[0].ID != "1_first.sql" {
+			t.Errorf("expected only untagged pre phase migration in %s, got: %+v", r.Target, r.Result.Executed)
+		}
+	}
+}
+
+func TestTenantsRunnerSearchPath(t *testing.T) {
+	tr := resetTenants(t)
+	if _, err := tr.DB.Exec("CREATE OR REPLACE FUNCTION public.tenant_default() RETURNS int LANGUAGE sql AS 'SELECT 1'"); err != nil {
+		t.Fatalf("could not create function: %s", err)
+	}
+	tr.Source = migrations.SourceDirect{
+		migrations.NewMigration("1_first.sql", "CREATE TABLE first (id int DEFAULT tenant_default())"),
+	}
+
+	if _, err := tr.Run(context.Background()); err != nil {
+		t.Fatalf("expected functions of public schema to be found: %s", err)
+	}
+	for _, schema := range tenantSchemas {
+		var exists bool
+		if err := tr.DB.QueryRow("SELECT to_regclass($1) IS NOT NULL", schema+".first").Scan(&exists); err != nil {
+			t.Fatalf("could not check table: %s", err)
+		}
+		if !exists {
+			t.Errorf("expected table created in schema %s", schema)
+		}
+	}
+}
+
+func TestTenantsRunnerSchemasQuery(t *testing.T) {
+	tr := resetTenants(t)
+	tr.Schemas = nil
+	tr.SchemasQuery = "SELECT unnest(ARRAY['tenant_b', 'tenant_a'])"
+
+	results, err := tr.Run(context.Background())
+	if err != nil {
+		t.Fatalf("could not migrate tenants: %s", err)
+	}
+	if len(results) != 2 || results[0].Target != "tenant_b" || results[1].Target != "tenant_a" {
+		t.Errorf("expected tenants selected by query, got: %+v", results)
+	}
+}
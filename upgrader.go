@@ -3,36 +3,186 @@ package migrations
 import (
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 )
 
-type Logger interface {
-	Println(v ...interface{})
-}
-
 type Upgrader struct {
 	Logger   Logger
 	Source   Source
 	Database Database
+	// Phase limits Do and Plan to migrations of given phase (PhasePre or PhasePost),
+	// migrations of all phases are executed when empty.
+	// Post phase fails while there are pending pre phase migrations.
+	Phase string
+	// IncludeTags and ExcludeTags select tagged migrations to execute, see MatchTags.
+	// Untagged migrations are always executed.
+	IncludeTags []string
+	ExcludeTags []string
 }
 
 type UpgradeResult struct {
 	Executed []Migration
 }
 
+// MigrationError is returned when migration execution or its recording fails.
+// Upgrade is never retried after it, see IsTransient.
+type MigrationError struct {
+	ID  string
+	Err error
+}
+
+func (e *MigrationError) Error() string {
+	return fmt.Sprintf("migration %s: %s", e.ID, e.Err)
+}
+
+func (e *MigrationError) Unwrap() error {
+	return e.Err
+}
+
 func (u *Upgrader) Do() (*UpgradeResult, error) {
-	migrationsSrc, err := u.Source.Migrations()
+	pending, squashed, err := u.pending()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(squashed) > 0 {
+		// originals were executed, record squashed migrations without running them
+		if err := u.baseline(squashed); err != nil {
+			return nil, fmt.Errorf("could not record squashed migrations: %w", err)
+		}
+		for _, mig := range squashed {
+			u.logger().Info("Squashed migration recorded as executed", "id", mig.ID)
+		}
+	}
+
+	executedNow := make([]Migration, 0, len(pending))
+	for _, mig := range pending {
+		if err = u.execute(mig); err != nil {
+			break
+		}
+		executedNow = append(executedNow, mig)
+	}
+
+	result := UpgradeResult{
+		Executed: executedNow,
+	}
+
+	if err != nil {
+		return &result, fmt.Errorf("failure during migrations execution: %w", err)
+	}
+
+	return &result, nil
+}
+
+// Plan returns migrations that Do would execute, in execution order.
+func (u *Upgrader) Plan() ([]Migration, error) {
+	run, _, err := u.pending()
+	return run, err
+}
+
+// Baseline marks versioned source migrations up to and including toID
+// as executed without running them, to adopt database whose schema
+// already exists. Already executed migrations are skipped.
+func (u *Upgrader) Baseline(toID string) (*UpgradeResult, error) {
+	migrationsSrc, err := sourceMigrations(u.Source)
 	if err != nil {
-		return nil, fmt.Errorf("could not read source migrations: %s", err)
+		return nil, err
+	}
+
+	upTo, err := migrationsUpTo(migrationsSrc, toID)
+	if err != nil {
+		return nil, err
+	}
+
+	executedAlready, err := u.Database.ExecutedMigrations()
+	if err != nil {
+		return nil, fmt.Errorf("could not get already executed migrations: %w", err)
+	}
+
+	executedByID := map[string]Executed{}
+	for _, mig := range executedAlready {
+		executedByID[mig.ID] = mig
+	}
+
+	baseline := make([]Migration, 0, len(upTo))
+	for _, mig := range upTo {
+		if mig.Repeatable {
+			continue
+		}
+		if _, ok := executedByID[mig.ID]; ok {
+			u.logger().Debug("Migration already executed", "id", mig.ID)
+			continue
+		}
+		if mig.Checksum == "" {
+			mig.Checksum = Checksum(mig.Content)
+		}
+		baseline = append(baseline, mig)
+	}
+
+	if len(baseline) > 0 {
+		if err := u.baseline(baseline); err != nil {
+			return nil, fmt.Errorf("could not record baseline: %w", err)
+		}
+	}
+	u.logger().Info("Baseline recorded", "to", toID, "count", len(baseline))
+
+	return &UpgradeResult{Executed: baseline}, nil
+}
+
+// sourceMigrations reads migrations from source in execution order.
+func sourceMigrations(src Source) ([]Migration, error) {
+	migs, err := src.Migrations()
+	if err != nil {
+		return nil, fmt.Errorf("could not read source migrations: %w", err)
+	}
+	return SortByDependencies(migs)
+}
+
+// migrationsUpTo returns migrations preceding one with given id, including it.
+func migrationsUpTo(migs []Migration, id string) ([]Migration, error) {
+	for i, mig := range migs {
+		if mig.ID == id {
+			return migs[:i+1], nil
+		}
+	}
+	return nil, fmt.Errorf("migration %s not found in source", id)
+}
+
+// pending returns migrations in order they need to be executed:
+// versioned migrations that are not executed yet, followed by
+// repeatable migrations that are new or changed since their last execution.
+// Separately it returns squashed migrations whose originals are all executed.
+// Only migrations of Upgrader phase are returned when it is set,
+// migrations excluded by tags are never returned, depending on ones not executed is an error.
+func (u *Upgrader) pending() (run []Migration, squashed []Migration, err error) {
+	migrationsSrc, err := sourceMigrations(u.Source)
+	if err != nil {
+		return nil, nil, err
 	}
 
 	if len(migrationsSrc) == 0 {
-		return nil, errors.New("no migrations to run")
+		return nil, nil, errors.New("no migrations to run")
+	}
+
+	if err := validateSquashes(migrationsSrc); err != nil {
+		return nil, nil, err
+	}
+
+	if u.Phase != "" {
+		if err := validatePhase(u.Phase); err != nil {
+			return nil, nil, err
+		}
+	}
+	for _, mig := range migrationsSrc {
+		if err := validatePhase(mig.phase()); err != nil {
+			return nil, nil, fmt.Errorf("migration %s: %w", mig.ID, err)
+		}
 	}
 
 	executedAlready, err := u.Database.ExecutedMigrations()
 	if err != nil {
-		return nil, fmt.Errorf("could not get already executed migrations: %s", err)
+		return nil, nil, fmt.Errorf("could not get already executed migrations: %w", err)
 	}
 
 	executedByID := map[string]Executed{}
@@ -40,44 +190,176 @@ func (u *Upgrader) Do() (*UpgradeResult, error) {
 		executedByID[mig.ID] = mig
 	}
 
-	executedNow := make([]Migration, 0, len(migrationsSrc))
+	repeatableLast, err := u.repeatableMigrations()
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not get executed repeatable migrations: %w", err)
+	}
+
+	repeatableByID := map[string]Executed{}
+	for _, mig := range repeatableLast {
+		repeatableByID[mig.ID] = mig
+	}
+
+	// excluded are migrations excluded by tags which were never executed
+	excluded := map[string]bool{}
+	var versioned, repeatable []Migration
 	for _, mig := range migrationsSrc {
+		if mig.Checksum == "" {
+			mig.Checksum = Checksum(mig.Content)
+		}
+
+		if !u.selected(mig) {
+			u.logger().Debug("Migration excluded by tags", "id", mig.ID, "tags", mig.Tags)
+			_, executed := executedByID[mig.ID]
+			_, executedRepeatable := repeatableByID[mig.ID]
+			excluded[mig.ID] = !executed && !executedRepeatable
+			continue
+		}
+
+		if mig.Repeatable {
+			if _, ok := u.Database.(RepeatableDatabase); !ok {
+				return nil, nil, fmt.Errorf("migration %s is repeatable, database does not support repeatable migrations", mig.ID)
+			}
+			if last, ok := repeatableByID[mig.ID]; ok && last.Checksum == mig.Checksum {
+				u.logger().Debug("Repeatable migration unchanged", "id", mig.ID)
+				continue
+			}
+			repeatable = append(repeatable, mig)
+			continue
+		}
+
 		if _, ok := executedByID[mig.ID]; ok {
+			u.logger().Debug("Migration already executed", "id", mig.ID)
 			continue
 		}
 
-		u.Println("Executing migration", mig.ID)
+		if len(mig.Squashes) > 0 {
+			var missing []string
+			for _, id := range mig.Squashes {
+				if _, ok := executedByID[id]; !ok {
+					missing = append(missing, id)
+				}
+			}
+			if len(missing) == 0 {
+				squashed = append(squashed, mig)
+				continue
+			}
+			if len(missing) != len(mig.Squashes) {
+				return nil, nil, fmt.Errorf("migration %s squashes partially executed migrations, not executed: %s", mig.ID, strings.Join(missing, ", "))
+			}
+		}
 
-		start := time.Now()
+		versioned = append(versioned, mig)
+	}
 
-		err = u.Database.Migrate(mig)
-		if err != nil {
-			break
+	run = append(versioned, repeatable...)
+	for _, mig := range run {
+		for _, dep := range mig.DependsOn {
+			if excluded[dep] {
+				return nil, nil, fmt.Errorf("migration %s depends on %s, which is excluded by tags and not executed", mig.ID, dep)
+			}
 		}
+	}
+	if u.Phase == "" {
+		return run, squashed, nil
+	}
 
-		duration := time.Since(start)
-		u.Println("Done,", duration)
+	var inPhase []Migration
+	// outOfPhase are pending migrations left for other phase
+	outOfPhase := map[string]bool{}
+	for _, mig := range run {
+		if mig.phase() == u.Phase {
+			inPhase = append(inPhase, mig)
+			continue
+		}
+		if u.Phase == PhasePost && mig.phase() == PhasePre {
+			return nil, nil, fmt.Errorf("%s phase migrations are pending, first is %s", PhasePre, mig.ID)
+		}
+		outOfPhase[mig.ID] = true
+	}
+	for _, mig := range inPhase {
+		for _, dep := range mig.DependsOn {
+			if outOfPhase[dep] {
+				return nil, nil, fmt.Errorf("migration %s of %s phase depends on pending migration %s of other phase", mig.ID, u.Phase, dep)
+			}
+		}
+	}
+	return inPhase, squashed, nil
+}
 
-		if err := u.Database.RecordMigration(mig.ID, duration); err != nil {
-			break
+// validateSquashes checks that squashed migrations are removed from source.
+func validateSquashes(migs []Migration) error {
+	squashedBy := map[string]string{}
+	for _, mig := range migs {
+		for _, id := range mig.Squashes {
+			squashedBy[id] = mig.ID
 		}
+	}
+	for _, mig := range migs {
+		if by, ok := squashedBy[mig.ID]; ok {
+			return fmt.Errorf("migration %s is squashed into %s and must be removed from source", mig.ID, by)
+		}
+	}
+	return nil
+}
 
-		executedNow = append(executedNow, mig)
+func (u *Upgrader) execute(mig Migration) error {
+	u.logger().Info("Executing migration", "id", mig.ID)
+
+	start := time.Now()
+
+	if err := u.Database.Migrate(mig); err != nil {
+		u.logger().Error("Migration failed", "id", mig.ID, "error", err)
+		return &MigrationError{ID: mig.ID, Err: err}
 	}
 
-	result := UpgradeResult{
-		Executed: executedNow,
+	duration := time.Since(start)
+	u.logger().Info("Migration executed", "id", mig.ID, "duration_ms", duration.Milliseconds())
+
+	if err := u.record(mig, duration); err != nil {
+		u.logger().Error("Recording migration failed", "id", mig.ID, "error", err)
+		return &MigrationError{ID: mig.ID, Err: err}
 	}
 
-	if err != nil {
-		return &result, fmt.Errorf("failure during migrations execution: %s", err)
+	return nil
+}
+
+func (u *Upgrader) repeatableMigrations() ([]Executed, error) {
+	if rd, ok := u.Database.(RepeatableDatabase); ok {
+		return rd.RepeatableMigrations()
 	}
+	return nil, nil
+}
 
-	return &result, nil
+func (u *Upgrader) record(mig Migration, duration time.Duration) error {
+	if er, ok := u.Database.(ExecutionRecorder); ok {
+		return er.RecordExecution(mig, duration)
+	}
+	return u.Database.RecordMigration(mig.ID, duration)
 }
 
+func (u *Upgrader) baseline(migs []Migration) error {
+	if b, ok := u.Database.(Baseliner); ok {
+		return b.Baseline(migs)
+	}
+	for _, mig := range migs {
+		if err := u.Database.RecordMigration(mig.ID, 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Println logs v as info message.
+//
+// Deprecated: use Logger, Println will be removed in next release.
 func (u *Upgrader) Println(v ...interface{}) {
-	if u.Logger != nil {
-		u.Logger.Println(v...)
+	u.logger().Info(strings.TrimSuffix(fmt.Sprintln(v...), "\n"))
+}
+
+func (u *Upgrader) logger() Logger {
+	if u.Logger == nil {
+		return nopLogger{}
 	}
+	return u.Logger
 }
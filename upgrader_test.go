@@ -2,6 +2,7 @@ package migrations_test
 
 import (
 	"errors"
+	"strings"
 	"testing"
 	"time"
 
@@ -9,24 +10,70 @@ import (
 )
 
 type DatabaseMock struct {
-	executed []migrations.Executed
+	executed   []migrations.Executed
+	repeatable []migrations.Executed
+	migrated   []string
 
 	migrateOverride   bool
 	migrateFailsAfter int
+
+	// returned by ExecutedMigrations one by one before succeeding
+	executedErrs []error
 }
 
 var someDBError = "some db error"
 
 func (dm *DatabaseMock) ExecutedMigrations() ([]migrations.Executed, error) {
+	if len(dm.executedErrs) > 0 {
+		err := dm.executedErrs[0]
+		dm.executedErrs = dm.executedErrs[1:]
+		return nil, err
+	}
 	return dm.executed, nil
 }
 
+func (dm *DatabaseMock) RepeatableMigrations() ([]migrations.Executed, error) {
+	last := map[string]migrations.Executed{}
+	for _, e := range dm.repeatable {
+		last[e.ID] = e
+	}
+	var result []migrations.Executed
+	for _, e := range last {
+		result = append(result, e)
+	}
+	return result, nil
+}
+
 func (dm *DatabaseMock) RecordMigration(id string, duration time.Duration) error {
-	dm.executed = append(dm.executed, migrations.Executed{
-		ID:         id,
+	return dm.RecordExecution(migrations.Migration{ID: id}, duration)
+}
+
+func (dm *DatabaseMock) RecordExecution(mig migrations.Migration, duration time.Duration) error {
+	executed := migrations.Executed{
+		ID:         mig.ID,
 		DurationMS: int(time.Millisecond),
 		ExecutedAt: time.Now(),
-	})
+		Checksum:   mig.Checksum,
+		Tags:       mig.Tags,
+	}
+	if mig.Repeatable {
+		dm.repeatable = append(dm.repeatable, executed)
+	} else {
+		dm.executed = append(dm.executed, executed)
+	}
+	return nil
+}
+
+func (dm *DatabaseMock) Baseline(migs []migrations.Migration) error {
+	for _, mig := range migs {
+		dm.executed = append(dm.executed, migrations.Executed{
+			ID:         mig.ID,
+			DurationMS: int(time.Millisecond),
+			ExecutedAt: time.Now(),
+			Checksum:   mig.Checksum,
+			Baseline:   true,
+		})
+	}
 	return nil
 }
 
@@ -37,6 +84,7 @@ func (dm *DatabaseMock) Migrate(mig migrations.Migration) error {
 		}
 		dm.migrateFailsAfter--
 	}
+	dm.migrated = append(dm.migrated, mig.ID)
 	return nil
 }
 
@@ -291,6 +339,264 @@ func TestErrorsDuringMigrations(t *testing.T) {
 	})
 }
 
+func TestRepeatableMigrations(t *testing.T) {
+	db := DatabaseMock{}
+
+	views := migrations.Migration{ID: "R__views.sql", Content: "CREATE VIEW v1", Repeatable: true}
+	first := migrations.Migration{ID: "1.sql", Content: "CREATE TABLE first"}
+
+	t.Run("repeatable executed after versioned", func(t *testing.T) {
+		src := migrations.SourceDirect{views, first}
+		u := migrations.Upgrader{
+			Source:   &src,
+			Database: &db,
+		}
+
+		result, err := u.Do()
+		if err != nil {
+			t.Fatalf("unexpected upgrader error: %s", err)
+		}
+		resultEquals(t, result, []migrations.Migration{first, views})
+		executedSliceEquals(t, db.executed, []string{first.ID})
+		executedSliceEquals(t, db.repeatable, []string{views.ID})
+	})
+
+	t.Run("unchanged repeatable is skipped", func(t *testing.T) {
+		src := migrations.SourceDirect{views, first}
+		u := migrations.Upgrader{
+			Source:   &src,
+			Database: &db,
+		}
+
+		result, err := u.Do()
+		if err != nil {
+			t.Fatalf("unexpected upgrader error: %s", err)
+		}
+		resultEquals(t, result, nil)
+	})
+
+	viewsChanged := views
+	viewsChanged.Content = "CREATE OR REPLACE VIEW v1"
+
+	t.Run("changed repeatable is executed again", func(t *testing.T) {
+		src := migrations.SourceDirect{viewsChanged, first}
+		u := migrations.Upgrader{
+			Source:   &src,
+			Database: &db,
+		}
+
+		result, err := u.Do()
+		if err != nil {
+			t.Fatalf("unexpected upgrader error: %s", err)
+		}
+		resultEquals(t, result, []migrations.Migration{viewsChanged})
+		executedSliceEquals(t, db.executed, []string{first.ID})
+		executedSliceEquals(t, db.repeatable, []string{views.ID, views.ID})
+		if db.repeatable[1].Checksum != migrations.Checksum(viewsChanged.Content) {
+			t.Errorf("expected new checksum to be recorded, got: %s", db.repeatable[1].Checksum)
+		}
+	})
+}
+
+func TestPlan(t *testing.T) {
+	db := DatabaseMock{}
+	db.RecordExecution(migrations.Migration{ID: "1.sql"}, 0)
+
+	src := migrations.SourceDirect{
+		{ID: "R__views.sql", Repeatable: true},
+		{ID: "1.sql"},
+		{ID: "2.sql"},
+	}
+	u := migrations.Upgrader{
+		Source:   &src,
+		Database: &db,
+	}
+
+	planned, err := u.Plan()
+	if err != nil {
+		t.Fatalf("unexpected plan error: %s", err)
+	}
+	resultEquals(t, &migrations.UpgradeResult{Executed: planned}, []migrations.Migration{src[2], src[0]})
+	if len(db.migrated) != 0 {
+		t.Fatalf("expected plan not to execute migrations, executed: %v", db.migrated)
+	}
+}
+
+func TestBaseline(t *testing.T) {
+	db := DatabaseMock{}
+
+	src := migrations.SourceDirect{
+		{ID: "001.sql"},
+		{ID: "002.sql"},
+		{ID: "R__views.sql", Repeatable: true},
+		{ID: "003.sql"},
+		{ID: "004.sql"},
+	}
+	u := migrations.Upgrader{
+		Source:   &src,
+		Database: &db,
+	}
+
+	if _, err := u.Baseline("unknown.sql"); err == nil {
+		t.Fatalf("expected to get error for unknown migration, got nil")
+	}
+
+	// pretend first one was already recorded by hand
+	db.RecordExecution(src[0], 0)
+
+	result, err := u.Baseline("003.sql")
+	if err != nil {
+		t.Fatalf("unexpected baseline error: %s", err)
+	}
+	resultEquals(t, result, []migrations.Migration{src[1], src[3]})
+	executedSliceEquals(t, db.executed, []string{"001.sql", "002.sql", "003.sql"})
+	if db.executed[0].Baseline || !db.executed[1].Baseline || !db.executed[2].Baseline {
+		t.Errorf("expected only baselined migrations to have baseline flag")
+	}
+
+	result, err = u.Do()
+	if err != nil {
+		t.Fatalf("unexpected upgrader error: %s", err)
+	}
+	resultEquals(t, result, []migrations.Migration{src[4], src[2]})
+}
+
+// legacyDatabase implements only Database, without optional interfaces.
+type legacyDatabase struct {
+	executed []migrations.Executed
+	migrated []string
+}
+
+func (ld *legacyDatabase) ExecutedMigrations() ([]migrations.Executed, error) {
+	return ld.executed, nil
+}
+
+func (ld *legacyDatabase) RecordMigration(id string, duration time.Duration) error {
+	ld.executed = append(ld.executed, migrations.Executed{ID: id, DurationMS: int(time.Millisecond), ExecutedAt: time.Now()})
+	return nil
+}
+
+func (ld *legacyDatabase) Migrate(mig migrations.Migration) error {
+	ld.migrated = append(ld.migrated, mig.ID)
+	return nil
+}
+
+func (ld *legacyDatabase) IsAlreadyExecuted(id string) (bool, error) {
+	panic("not implemented")
+}
+
+func TestLegacyDatabase(t *testing.T) {
+	db := legacyDatabase{}
+
+	src := migrations.SourceDirect{
+		{ID: "001.sql"},
+		{ID: "002.sql"},
+		{ID: "003.sql"},
+	}
+	u := migrations.Upgrader{
+		Source:   &src,
+		Database: &db,
+	}
+
+	if _, err := u.Baseline("001.sql"); err != nil {
+		t.Fatalf("unexpected baseline error: %s", err)
+	}
+	result, err := u.Do()
+	if err != nil {
+		t.Fatalf("unexpected upgrader error: %s", err)
+	}
+	resultEquals(t, result, []migrations.Migration{src[1], src[2]})
+	executedSliceEquals(t, db.executed, []string{"001.sql", "002.sql", "003.sql"})
+	if len(db.migrated) != 2 {
+		t.Errorf("expected baselined migration not to be executed, executed: %v", db.migrated)
+	}
+
+	if _, err := u.Status(); err != nil {
+		t.Fatalf("unexpected status error: %s", err)
+	}
+
+	src = append(src, migrations.Migration{ID: "R__views.sql", Repeatable: true})
+	_, err = u.Do()
+	if err == nil || !strings.Contains(err.Error(), "does not support repeatable migrations") {
+		t.Fatalf("expected error about unsupported repeatable migrations, got: %v", err)
+	}
+}
+
+func TestSquashedMigrations(t *testing.T) {
+	squashed := migrations.NewMigration("002_squashed.sql", "-- migrate:squashes 001.sql 002.sql\nCREATE TABLE ...")
+	next := migrations.Migration{ID: "003.sql"}
+
+	t.Run("originals executed", func(t *testing.T) {
+		db := DatabaseMock{}
+		db.RecordExecution(migrations.Migration{ID: "001.sql"}, 0)
+		db.RecordExecution(migrations.Migration{ID: "002.sql"}, 0)
+
+		src := migrations.SourceDirect{squashed, next}
+		u := migrations.Upgrader{
+			Source:   &src,
+			Database: &db,
+		}
+
+		result, err := u.Do()
+		if err != nil {
+			t.Fatalf("unexpected upgrader error: %s", err)
+		}
+		resultEquals(t, result, []migrations.Migration{next})
+		executedSliceEquals(t, db.executed, []string{"001.sql", "002.sql", squashed.ID, next.ID})
+		if !db.executed[2].Baseline {
+			t.Errorf("expected squashed migration to be recorded as baseline")
+		}
+		if len(db.migrated) != 1 {
+			t.Errorf("expected squashed migration not to be executed, executed: %v", db.migrated)
+		}
+	})
+
+	t.Run("fresh database", func(t *testing.T) {
+		db := DatabaseMock{}
+
+		src := migrations.SourceDirect{squashed, next}
+		u := migrations.Upgrader{
+			Source:   &src,
+			Database: &db,
+		}
+
+		result, err := u.Do()
+		if err != nil {
+			t.Fatalf("unexpected upgrader error: %s", err)
+		}
+		resultEquals(t, result, []migrations.Migration{squashed, next})
+	})
+
+	t.Run("originals partially executed", func(t *testing.T) {
+		db := DatabaseMock{}
+		db.RecordExecution(migrations.Migration{ID: "001.sql"}, 0)
+
+		src := migrations.SourceDirect{squashed, next}
+		u := migrations.Upgrader{
+			Source:   &src,
+			Database: &db,
+		}
+
+		if _, err := u.Do(); err == nil {
+			t.Fatalf("expected to get error, got nil")
+		}
+	})
+
+	t.Run("originals left in source", func(t *testing.T) {
+		db := DatabaseMock{}
+
+		src := migrations.SourceDirect{{ID: "001.sql"}, squashed, next}
+		u := migrations.Upgrader{
+			Source:   &src,
+			Database: &db,
+		}
+
+		if _, err := u.Do(); err == nil {
+			t.Fatalf("expected to get error, got nil")
+		}
+	})
+}
+
 func resultEquals(t *testing.T, r *migrations.UpgradeResult, executedNow []migrations.Migration) {
 	t.Helper()
 	if r == nil {
@@ -301,6 +607,9 @@ func resultEquals(t *testing.T, r *migrations.UpgradeResult, executedNow []migra
 	}
 	for i, e := range r.Executed {
 		expected := executedNow[i]
+		if e.ID != expected.ID {
+			t.Errorf("executed migration %d id should be %s, got: %s", i, expected.ID, e.ID)
+		}
 		if e.Content != expected.Content {
 			t.Errorf("executed migration %d content should be %s, got: %s", i, expected.Content, e.Content)
 		}
@@ -0,0 +1,48 @@
+package migrations
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// varPattern matches ${name} placeholder, or escaped $${name} kept as ${name}.
+var varPattern = regexp.MustCompile(`\$?\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// RenderVars replaces ${name} placeholders in content with values of vars.
+// Placeholder can be escaped as $${name}. Undefined variables are errors,
+// nil vars have no variables defined.
+func RenderVars(content string, vars map[string]string) (string, error) {
+	var undefined []string
+	seen := map[string]bool{}
+	rendered := varPattern.ReplaceAllStringFunc(content, func(match string) string {
+		if strings.HasPrefix(match, "$$") {
+			return match[1:]
+		}
+		name := varPattern.FindStringSubmatch(match)[1]
+		value, ok := vars[name]
+		if !ok {
+			if !seen[name] {
+				seen[name] = true
+				undefined = append(undefined, name)
+			}
+			return match
+		}
+		return value
+	})
+	if len(undefined) > 0 {
+		return "", fmt.Errorf("undefined variables: %s", strings.Join(undefined, ", "))
+	}
+	return rendered, nil
+}
+
+// renderMigration renders content of migration, keeping checksum of raw content,
+// so that changing variable values does not make executed migrations modified.
+func renderMigration(mig Migration, vars map[string]string) (Migration, error) {
+	rendered, err := RenderVars(mig.Content, vars)
+	if err != nil {
+		return mig, fmt.Errorf("migration %s: %w", mig.ID, err)
+	}
+	mig.Content = rendered
+	return mig, nil
+}
@@ -0,0 +1,132 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Backoff is exponential retry policy.
+type Backoff struct {
+	// Initial delay between attempts, doubled after each one, defaults to 500ms.
+	Initial time.Duration
+	// Max delay between attempts, defaults to 10s.
+	Max time.Duration
+	// MaxWait is total time to keep retrying, zero means until context is done.
+	MaxWait time.Duration
+}
+
+func (b Backoff) delay(attempt int) time.Duration {
+	initial := b.Initial
+	if initial <= 0 {
+		initial = 500 * time.Millisecond
+	}
+	max := b.Max
+	if max <= 0 {
+		max = 10 * time.Second
+	}
+	delay := initial
+	for i := 0; i < attempt && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+	return delay
+}
+
+// IsTransient reports whether error is caused by unavailable database
+// and operation may succeed when retried.
+// Errors of migrations themselves (MigrationError) are never transient.
+func IsTransient(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var migErr *MigrationError
+	if errors.As(err, &migErr) {
+		return false
+	}
+
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch {
+		// connection_exception class
+		case strings.HasPrefix(string(pqErr.Code), "08"):
+			return true
+		// cannot_connect_now (starting up), too_many_connections, admin_shutdown
+		case pqErr.Code == "57P03", pqErr.Code == "53300", pqErr.Code == "57P01":
+			return true
+		}
+	}
+
+	return false
+}
+
+// retry calls fn until it succeeds, fails with not transient error or backoff wait is exceeded.
+// fn gets context which is done when wait is exceeded.
+func retry(ctx context.Context, backoff Backoff, logger Logger, fn func(ctx context.Context) error) error {
+	if backoff.MaxWait > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, backoff.MaxWait)
+		defer cancel()
+	}
+
+	for attempt := 0; ; attempt++ {
+		err := fn(ctx)
+		if err == nil || !IsTransient(err) {
+			return err
+		}
+
+		delay := backoff.delay(attempt)
+		logger.Warn("Database is unavailable, retrying", "attempt", attempt+1, "delay_ms", delay.Milliseconds(), "error", err)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("gave up after %d attempts: %w", attempt+1, err)
+		case <-timer.C:
+		}
+	}
+}
+
+// WaitForDB pings database until it becomes available.
+// Logger can be nil.
+func WaitForDB(ctx context.Context, db *sql.DB, backoff Backoff, logger Logger) error {
+	if logger == nil {
+		logger = nopLogger{}
+	}
+	return retry(ctx, backoff, logger, func(ctx context.Context) error {
+		return db.PingContext(ctx)
+	})
+}
+
+// DoWithRetry runs Do, retrying when database is unavailable.
+// Failed migrations are never retried, see IsTransient.
+func (u *Upgrader) DoWithRetry(ctx context.Context, backoff Backoff) (*UpgradeResult, error) {
+	var result *UpgradeResult
+	err := retry(ctx, backoff, u.logger(), func(context.Context) error {
+		var err error
+		result, err = u.Do()
+		return err
+	})
+	return result, err
+}
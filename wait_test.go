@@ -0,0 +1,134 @@
+package migrations_test
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+	migrations "github.com/ulexxander/go-db-migrations"
+)
+
+func TestIsTransient(t *testing.T) {
+	tests := []struct {
+		err       error
+		transient bool
+	}{
+		{nil, false},
+		{errors.New("some error"), false},
+		{driver.ErrBadConn, true},
+		{fmt.Errorf("wrapped: %w", &net.OpError{Op: "dial", Err: errors.New("connection refused")}), true},
+		{&pq.Error{Code: "57P03"}, true},
+		{&pq.Error{Code: "08006"}, true},
+		{&pq.Error{Code: "42601"}, false},
+		{&migrations.MigrationError{ID: "1.sql", Err: driver.ErrBadConn}, false},
+	}
+
+	for _, tt := range tests {
+		if got := migrations.IsTransient(tt.err); got != tt.transient {
+			t.Errorf("expected IsTransient(%v) to be %t, got: %t", tt.err, tt.transient, got)
+		}
+	}
+}
+
+var testBackoff = migrations.Backoff{
+	Initial: time.Millisecond,
+	Max:     time.Millisecond,
+	MaxWait: time.Second,
+}
+
+func TestDoWithRetry(t *testing.T) {
+	db := DatabaseMock{
+		executedErrs: []error{driver.ErrBadConn, &pq.Error{Code: "57P03"}},
+	}
+
+	src := migrations.SourceDirect{{ID: "1.sql"}}
+	u := migrations.Upgrader{
+		Source:   &src,
+		Database: &db,
+	}
+
+	result, err := u.DoWithRetry(context.Background(), testBackoff)
+	if err != nil {
+		t.Fatalf("unexpected upgrader error: %s", err)
+	}
+	resultEquals(t, result, src)
+}
+
+func TestDoWithRetryGivesUp(t *testing.T) {
+	db := DatabaseMock{}
+	for i := 0; i < 100; i++ {
+		db.executedErrs = append(db.executedErrs, driver.ErrBadConn)
+	}
+
+	src := migrations.SourceDirect{{ID: "1.sql"}}
+	u := migrations.Upgrader{
+		Source:   &src,
+		Database: &db,
+	}
+
+	backoff := testBackoff
+	backoff.MaxWait = 20 * time.Millisecond
+	if _, err := u.DoWithRetry(context.Background(), backoff); !errors.Is(err, driver.ErrBadConn) {
+		t.Fatalf("expected to get connection error, got: %v", err)
+	}
+}
+
+func TestDoWithRetryNotRetryingMigrationErrors(t *testing.T) {
+	db := DatabaseMock{
+		migrateOverride: true,
+	}
+
+	src := migrations.SourceDirect{{ID: "1.sql"}}
+	u := migrations.Upgrader{
+		Source:   &src,
+		Database: &db,
+	}
+
+	result, err := u.DoWithRetry(context.Background(), testBackoff)
+	var migErr *migrations.MigrationError
+	if !errors.As(err, &migErr) {
+		t.Fatalf("expected to get migration error, got: %v", err)
+	}
+	if migErr.ID != "1.sql" {
+		t.Errorf("expected failed migration to be 1.sql, got: %s", migErr.ID)
+	}
+	resultEquals(t, result, nil)
+}
+
+// hangingConnector connects only when context is done.
+type hangingConnector struct{}
+
+func (hangingConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (hangingConnector) Driver() driver.Driver {
+	return nil
+}
+
+func TestWaitForDBStopsHangingPing(t *testing.T) {
+	db := sql.OpenDB(hangingConnector{})
+	defer db.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		backoff := testBackoff
+		backoff.MaxWait = 20 * time.Millisecond
+		done <- migrations.WaitForDB(context.Background(), db, backoff, nil)
+	}()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatalf("expected error of hanging database")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected ping to stop after max wait")
+	}
+}